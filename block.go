@@ -28,3 +28,40 @@ type Block[H Hash] interface {
 	// disabled.
 	SetTransactions([]Transaction[H])
 }
+
+// NextConsensusBlock is an optional Block extension exposing the commitment
+// to the next block validators list (like NextConsensus field of Neo blocks).
+// If the header implements it and Config.ValidatorsHash is set, dBFT checks
+// that the commitment matches Context.NextValidators before accepting the
+// proposal and before signing the header, so that nodes that disagree on the
+// next validators never approve the block.
+type NextConsensusBlock[H Hash] interface {
+	Block[H]
+	// NextConsensus returns the hash of the next block validators list that
+	// is to be equal to Config.ValidatorsHash result for this list.
+	NextConsensus() H
+}
+
+// BatchVerifier is an optional interface that can be implemented by Block
+// and PreBlock to verify several signatures (PreCommit data) at once. It's
+// used for payloads extracted from RecoveryMessage.
+type BatchVerifier interface {
+	// VerifyBatch checks signs[i] made with keys[i] the same way Verify does
+	// and returns the result of every check, so the length of the resulting
+	// slice must be equal to the length of keys.
+	VerifyBatch(keys []PublicKey, signs [][]byte) []error
+}
+
+// PreparationSigner is an optional Block extension required for fast path
+// (see Config.FastPathEnablingHeight). Preparation signatures must be made
+// over a message that differs from the one signed by Sign for any block (like
+// the header hash data with some fixed prefix), so that they're never valid
+// as block (Commit) signatures and vice versa. Otherwise preparations of a
+// block that wasn't accepted (nodes may change view after sending them) can
+// be combined into a valid block witness.
+type PreparationSigner interface {
+	// SignPreparation returns the preparation signature of the block.
+	SignPreparation(key PrivateKey) ([]byte, error)
+	// VerifyPreparation checks the preparation signature of the block.
+	VerifyPreparation(key PublicKey, sign []byte) error
+}
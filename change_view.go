@@ -3,8 +3,11 @@ package dbft
 // ChangeView represents dBFT ChangeView message.
 type ChangeView interface {
 	// NewViewNumber returns proposed view number.
-	NewViewNumber() byte
+	NewViewNumber() View
 
 	// Reason returns change view reason.
 	Reason() ChangeViewReason
+
+	// Timestamp returns this message's timestamp.
+	Timestamp() uint64
 }
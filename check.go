@@ -1,6 +1,8 @@
 package dbft
 
 import (
+	"errors"
+
 	"go.uber.org/zap"
 )
 
@@ -16,37 +18,88 @@ func (d *DBFT[H]) checkPrepare() {
 		d.Logger.Debug("check prepare: some transactions are missing", zap.Any("hashes", d.MissingTransactions))
 		return
 	}
-
-	count := 0
-	hasRequest := false
-
-	for _, msg := range d.PreparationPayloads {
-		if msg != nil {
-			if msg.ViewNumber() == d.ViewNumber {
-				count++
-			}
-
-			if msg.Type() == PrepareRequestType {
-				hasRequest = true
-			}
-		}
+	if d.blockVerifying {
+		d.Logger.Debug("check prepare: block verification is in progress")
+		return
 	}
 
+	count := d.counters.preparations[d.ViewNumber]
+	hasRequest := d.RequestSentOrReceived()
+
 	d.Logger.Debug("check preparations", zap.Bool("hasReq", hasRequest),
 		zap.Int("count", count),
 		zap.Int("M", d.M()))
 
 	if hasRequest && count >= d.M() {
+		if d.pendingBlock != nil {
+			// See Config.Pipelined, the check is repeated on Reset.
+			d.Logger.Debug("check prepare: waiting for the previous block to be persisted")
+			return
+		}
 		if d.isAntiMEVExtensionEnabled() {
 			d.sendPreCommit()
-			d.changeTimer(d.timePerBlock)
+			d.changeTimer(d.commitTimeout(false))
 			d.checkPreCommit()
 		} else {
 			d.sendCommit()
-			d.changeTimer(d.timePerBlock)
-			d.checkCommit()
+			d.changeTimer(d.commitTimeout(false))
+			if !d.checkFastPath() {
+				d.checkCommit()
+			}
+		}
+	}
+}
+
+// checkFastPath approves the block if fast path is enabled and preparations
+// with valid preparation signatures from all validators are collected, it
+// returns true if so. Commit is still sent before that for the nodes that
+// don't have all preparations, they approve the same block the usual way.
+func (d *DBFT[H]) checkFastPath() bool {
+	if !d.isFastPathEnabled() || d.blockProcessed || !d.RequestSentOrReceived() || !d.hasAllTransactions() {
+		return false
+	}
+
+	if d.counters.preparations[d.ViewNumber] < d.TotalWeight() {
+		return false
+	}
+
+	header := d.MakeHeader()
+	if header == nil {
+		return false
+	}
+	for i, m := range d.PreparationPayloads {
+		if m == nil && d.Weight(i) == 0 {
+			continue
+		}
+		if err := verifyPreparation(header, d.Validators[i], m); err != nil {
+			d.Logger.Debug("can't approve block via fast path",
+				zap.Int("validator", i),
+				zap.Error(err))
+			return false
 		}
 	}
+
+	d.block = d.CreateBlock()
+	d.FastPathApproved = true
+
+	d.Logger.Info("approving block via fast path",
+		zap.Uint32("height", d.BlockIndex),
+		zap.Stringer("hash", d.block.Hash()),
+		zap.Int("tx_count", len(d.block.Transactions())),
+		zap.Stringer("merkle", d.block.MerkleRoot()),
+		zap.Stringer("prev", d.block.PrevHash()))
+
+	err := d.ProcessBlock(d.block)
+	if err != nil {
+		d.Logger.Fatal("block processing failed", zap.Error(err))
+	}
+
+	if d.ProcessFinalityProof != nil {
+		d.ProcessFinalityProof(d.makeFinalityProof())
+	}
+
+	d.onBlockProcessed()
+	return true
 }
 
 func (d *DBFT[H]) checkPreCommit() {
@@ -55,12 +108,7 @@ func (d *DBFT[H]) checkPreCommit() {
 		return
 	}
 
-	count := 0
-	for _, msg := range d.PreCommitPayloads {
-		if msg != nil && msg.ViewNumber() == d.ViewNumber {
-			count++
-		}
-	}
+	count := d.counters.preCommits[d.ViewNumber]
 
 	if count < d.M() {
 		d.Logger.Debug("not enough PreCommits to process PreBlock", zap.Int("count", count))
@@ -94,7 +142,7 @@ func (d *DBFT[H]) checkPreCommit() {
 	if d.PreCommitSent() {
 		d.verifyCommitPayloadsAgainstHeader()
 		d.sendCommit()
-		d.changeTimer(d.timePerBlock)
+		d.changeTimer(d.commitTimeout(false))
 		d.checkCommit()
 	} else {
 		if !d.Context.WatchOnly() {
@@ -111,13 +159,7 @@ func (d *DBFT[H]) checkCommit() {
 
 	// return if we received commits from other nodes
 	// before receiving PrepareRequest from Speaker
-	count := 0
-
-	for _, msg := range d.CommitPayloads {
-		if msg != nil && msg.ViewNumber() == d.ViewNumber {
-			count++
-		}
-	}
+	count := d.counters.commits[d.ViewNumber]
 
 	if count < d.M() {
 		d.Logger.Debug("not enough to commit", zap.Int("count", count))
@@ -145,27 +187,70 @@ func (d *DBFT[H]) checkCommit() {
 		d.Logger.Fatal("block processing failed", zap.Error(err))
 	}
 
+	if d.ProcessFinalityProof != nil {
+		d.ProcessFinalityProof(d.makeFinalityProof())
+	}
+
+	d.onBlockProcessed()
+}
+
+// onBlockProcessed updates the state after the block is passed to
+// ProcessBlock.
+func (d *DBFT[H]) onBlockProcessed() {
 	d.blockProcessed = true
+	d.updateReputation()
+
+	// Only one block can be pending, otherwise wait for Reset. Observer
+	// doesn't wait for persistence at all.
+	if d.Observer || d.Pipelined && d.pendingBlock == nil {
+		d.startNextHeight()
+	}
 
 	// Do not initialize consensus process immediately. It's the caller's duty to
 	// start the new block acceptance process and call Reset at the
 	// new height.
 }
 
-func (d *DBFT[H]) checkChangeView(view byte) {
-	if d.ViewNumber >= view {
+// checkObservedCommits verifies Commits received before the header could be
+// constructed and tries to approve the block. It's used by WatchOnly nodes
+// that never reach the commit stage on their own.
+func (d *DBFT[H]) checkObservedCommits() {
+	if d.isAntiMEVExtensionEnabled() {
 		return
 	}
+	d.verifyCommitPayloadsAgainstHeader()
+	d.checkCommit()
+}
+
+// startNextHeight starts consensus for the next height on top of the
+// approved block in pipelined mode.
+func (d *DBFT[H]) startNextHeight() {
+	if d.Pipelined {
+		d.pendingContext = d.Context.detach()
+	}
+	d.pendingBlock = d.block
+	d.Logger.Info("starting the next height before block is persisted",
+		zap.Uint32("height", d.BlockIndex+1))
+	d.initializeConsensus(0, d.Timestamp)
+}
 
-	count := 0
+// updateReputation records proposals failed at the previous views of the
+// current height and the accepted one.
+func (d *DBFT[H]) updateReputation() {
+	if d.Reputation == nil {
+		return
+	}
+	d.Reputation.AddBlock(d.BlockIndex, d.ViewNumber, d.Validators, func(_ uint32, v View, _ []PublicKey) uint {
+		return d.GetPrimaryIndex(v)
+	})
+}
 
-	for _, msg := range d.ChangeViewPayloads {
-		if msg != nil && msg.GetChangeView().NewViewNumber() >= view {
-			count++
-		}
+func (d *DBFT[H]) checkChangeView(view View) {
+	if d.ViewNumber >= view {
+		return
 	}
 
-	if count < d.M() {
+	if d.changeViewWeight(view) < d.M() {
 		return
 	}
 
@@ -178,3 +263,28 @@ func (d *DBFT[H]) checkChangeView(view byte) {
 
 	d.initializeConsensus(view, d.lastBlockTimestamp)
 }
+
+// preparation returns PrepareRequest or PrepareResponse carried by m.
+func preparation[H Hash](m ConsensusPayload[H]) any {
+	if m.Type() == PrepareRequestType {
+		return m.GetPrepareRequest()
+	}
+	return m.GetPrepareResponse()
+}
+
+// verifyPreparation checks the preparation signature of the header carried
+// by preparation m (see SignedPreparation and PreparationSigner).
+func verifyPreparation[H Hash](header Block[H], pub PublicKey, m ConsensusPayload[H]) error {
+	if m == nil {
+		return errors.New("no preparation")
+	}
+	sp, ok := preparation(m).(SignedPreparation)
+	if !ok || sp.Signature() == nil {
+		return errors.New("unsigned preparation")
+	}
+	ps, ok := header.(PreparationSigner)
+	if !ok {
+		return errors.New("header doesn't support preparation signatures")
+	}
+	return ps.VerifyPreparation(pub, sp.Signature())
+}
@@ -0,0 +1,78 @@
+package dbft
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// clockState is a clock offset estimate of a single validator.
+type clockState struct {
+	offset time.Duration
+	skewed bool
+}
+
+// ClockOffset returns estimated clock offset of the validator with the given
+// index relative to the local clock, it's positive if validator's clock is
+// ahead. It's estimated from PrepareRequest, ChangeView and RecoveryRequest
+// timestamps taking RTT into account and is zero if unknown. Estimates are
+// tracked by validators' public keys and kept across heights while the
+// validator stays in the validators list.
+func (c *Context[H]) ClockOffset(i int) time.Duration {
+	if i < 0 || i >= len(c.Validators) {
+		return 0
+	}
+	if s := c.clocks.lookup(c.Validators[i]); s != nil {
+		return s.offset
+	}
+	return 0
+}
+
+// updateClockOffset adds a new sample of validator's clock offset based on
+// the message timestamp (in nanoseconds) and notifies about skew exceeding
+// Config.MaxClockSkew.
+func (d *DBFT[H]) updateClockOffset(validator uint16, ts uint64) {
+	var i = int(validator)
+	if d.recovery != nil || ts == 0 || i == d.MyIndex || i >= len(d.Validators) {
+		return
+	}
+	var s = d.clocks.get(d.Validators[i])
+	if s == nil {
+		return
+	}
+
+	// Message was sent about RTT/2 ago.
+	var (
+		now    = d.Timer.Now().Add(-d.RTTEstimator.Average(PrepareRTT) / 2)
+		sample = time.Duration(int64(ts) - now.UnixNano())
+	)
+	if s.offset == 0 {
+		s.offset = sample
+	} else {
+		s.offset = (7*s.offset + sample) / 8
+	}
+
+	if d.MaxClockSkew == 0 {
+		return
+	}
+	var skewed = s.offset > d.MaxClockSkew || s.offset < -d.MaxClockSkew
+	if skewed && !s.skewed {
+		d.Logger.Warn("validator clock skew detected",
+			zap.Uint16("validator", validator),
+			zap.Duration("offset", s.offset))
+		if d.OnClockSkew != nil {
+			d.OnClockSkew(i, s.offset)
+		}
+	}
+	s.skewed = skewed
+}
+
+// isTimestampTooFar checks whether PrepareRequest timestamp exceeds both local
+// time and the previous block timestamp by more than Config.MaxTimestampDrift.
+func (d *DBFT[H]) isTimestampTooFar(ts uint64) bool {
+	if d.MaxTimestampDrift == 0 {
+		return false
+	}
+	var limit = max(uint64(d.Timer.Now().UnixNano()), d.lastBlockTimestamp) + uint64(d.MaxTimestampDrift)
+	return ts > limit
+}
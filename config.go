@@ -25,6 +25,14 @@ type Config[H Hash] struct {
 	// transactions in the node's pool, ref.
 	// https://github.com/neo-project/neo/issues/4018.
 	MaxTimePerBlock func() time.Duration
+	// MaxViewNumber is the maximum view number dBFT can change view to
+	// within a single height, it's DefaultMaxViewNumber by default which
+	// allows to use a single byte for view in consensus messages. Once it's
+	// reached, nodes only send RecoveryRequest on timeout.
+	MaxViewNumber View
+	// TimeoutPolicy defines view change and Commit resend timeouts. It's
+	// DefaultTimeoutPolicy by default.
+	TimeoutPolicy TimeoutPolicy
 	// TimestampIncrement increment is the amount of units to add to timestamp
 	// if current time is less than that of previous context.
 	// By default use millisecond precision.
@@ -32,6 +40,19 @@ type Config[H Hash] struct {
 	// AntiMEVExtensionEnablingHeight denotes the height starting from which dBFT
 	// Anti-MEV extensions should be enabled. -1 means no extension is enabled.
 	AntiMEVExtensionEnablingHeight int64
+	// FastPathEnablingHeight denotes the height starting from which a block
+	// can be approved as soon as matching preparations from all validators
+	// are collected, without waiting for Commits (see
+	// Context.FastPathApproved). Preparations must implement
+	// SignedPreparation and carry valid preparation signatures of the header
+	// implementing PreparationSigner then, otherwise the block is approved
+	// via Commits only. To keep it safe, a node that has sent its
+	// preparation at these heights doesn't send ChangeView unless more than
+	// F validators have already requested view change and a node that has
+	// sent ChangeView doesn't send preparation in the same view. -1 means
+	// fast path is disabled. Fast path is not used at heights Anti-MEV
+	// extension is enabled at.
+	FastPathEnablingHeight int64
 	// GetKeyPair returns an index of the node in the list of validators
 	// together with it's key pair.
 	GetKeyPair func([]PublicKey) (int, PrivateKey, PublicKey)
@@ -43,6 +64,23 @@ type Config[H Hash] struct {
 	// in current block can't be found in memory pool. The slice received by
 	// this callback MUST NOT be changed.
 	RequestTx func(h ...H)
+	// RequestTxFrom, if set, is used instead of RequestTx. It additionally
+	// gets an index of the validator known to have the transactions: the
+	// primary for the first request and validators that have sent
+	// PrepareResponse for the proposal on retries.
+	RequestTxFrom func(validator int, h ...H)
+	// TxRetryInterval, if set, enables re-requesting of missing transactions
+	// with exponential backoff starting from this interval and limited by
+	// MaxTxRetryInterval. Retries are only made from DBFT.OnTxRetry, so it
+	// must be called periodically then.
+	TxRetryInterval time.Duration
+	// MaxTxRetryInterval is the maximum interval between missing
+	// transaction requests, it must not be less than TxRetryInterval.
+	MaxTxRetryInterval time.Duration
+	// OnTxFetched, if set, is called for every missing transaction once it's
+	// received with the time passed since the first request and the number
+	// of requests made. It can be used for metrics.
+	OnTxFetched func(h H, latency time.Duration, attempts int)
 	// SubscribeForTxs is a callback which is called when dBFT needs to track incoming
 	// mempool transactions. Subscription is supposed to be single-use, no unsubscription
 	// is initiated by dBFT, hence it's the user's duty to manage and release resources.
@@ -56,16 +94,57 @@ type Config[H Hash] struct {
 	// GetVerified returns a slice of verified transactions
 	// to be proposed in a new block.
 	GetVerified func() []Transaction[H]
+	// VerifyTransaction verifies proposed transaction when it's collected by
+	// backup node, see ErrTxRejectedByPolicy and ErrTxInvalid for the errors
+	// it can return. It's optional, whole block is checked with VerifyBlock
+	// anyway.
+	VerifyTransaction func(tx Transaction[H]) error
+	// OnTxRejected, if set, is called with the hash of every proposed
+	// transaction that fails VerifyTransaction and the error returned. It's
+	// called before ChangeView is sent, so the node can e.g. ban the
+	// transaction or penalize the proposer.
+	OnTxRejected func(h H, err error)
+	// MaxProposalTransactions is the maximum number of transactions in the
+	// proposal, 0 means no limit. Primary takes the first transactions
+	// returned by GetVerified that fit into the limits, backups reject
+	// proposals exceeding them.
+	MaxProposalTransactions int
+	// MaxProposalSize is the maximum total size of proposed transactions in
+	// bytes, 0 means no limit. Transaction size is known only for transactions
+	// implementing SizedTransaction.
+	MaxProposalSize int
+	// MaxProposalWeight is the maximum total weight (e.g. gas) of proposed
+	// transactions computed with TransactionWeight, 0 means no limit.
+	MaxProposalWeight uint64
+	// TransactionWeight returns the weight of the transaction. It must be set
+	// if MaxProposalWeight is set.
+	TransactionWeight func(tx Transaction[H]) uint64
 	// VerifyPreBlock verifies if preBlock is valid.
 	VerifyPreBlock func(b PreBlock[H]) bool
 	// VerifyBlock verifies if block is valid.
 	VerifyBlock func(b Block[H]) bool
 	// Broadcast should broadcast payload m to the consensus nodes.
 	Broadcast func(m ConsensusPayload[H])
+	// SendTo, if set, should send payload m to the consensus node with the
+	// given validator index only. It's used for RecoveryMessage replies to
+	// RecoveryRequest and ChangeView payloads, Broadcast is used for them
+	// if SendTo is not set. RecoveryMessages re-sending node's own
+	// preparation or Commit on timeout are always broadcasted since it's
+	// not known which nodes miss them.
+	SendTo func(validator int, m ConsensusPayload[H])
 	// ProcessBlock is called every time new preBlock is accepted.
 	ProcessPreBlock func(b PreBlock[H]) error
 	// ProcessBlock is called every time new block is accepted.
 	ProcessBlock func(b Block[H]) error
+	// ProcessFinalityProof, if set, is called right after ProcessBlock with
+	// FinalityProof of the accepted block. For blocks approved via fast path
+	// (see Context.FastPathApproved) the proof is a FastPath one made of
+	// preparation signatures of all validators.
+	ProcessFinalityProof func(p *FinalityProof[H])
+	// ValidatorsHash returns a hash of the given validators list to be used
+	// in FinalityProof and to check NextConsensusBlock headers. It must be
+	// set if ProcessFinalityProof is set.
+	ValidatorsHash func(validators []PublicKey) H
 	// GetBlock should return block with hash.
 	GetBlock func(h H) Block[H]
 	// WatchOnly tells if a node should only watch.
@@ -76,9 +155,67 @@ type Config[H Hash] struct {
 	CurrentBlockHash func() H
 	// GetValidators returns list of the validators.
 	// When called with a transaction list it must return
-	// list of the validators of the next block.
+	// list of the validators of the next block. dBFT calls it with the
+	// proposed transactions to compute Context.NextValidators and uses
+	// the result for the next height if the block is accepted by this node.
 	// If this function ever returns 0-length slice, dbft will panic.
 	GetValidators func(...Transaction[H]) []PublicKey
+	// PrimarySelector returns an index of the primary node for the given
+	// height and view among the given validators. It must be deterministic
+	// and return the same value on all nodes for the same arguments, the
+	// result must be less than len(validators), DefaultPrimarySelector
+	// result is used (and an error is logged) otherwise. By default,
+	// round-robin selection is used (see DefaultPrimarySelector).
+	PrimarySelector func(height uint32, view View, validators []PublicKey) uint
+	// ValidatorWeights, if set, returns voting weights of the given
+	// validators (like their stakes). Quorums are then computed as more than
+	// 2/3 of the total weight instead of the number of validators. The result
+	// must have the same length as validators, weight must not exceed
+	// math.MaxInt32 and the total weight must be non-zero, dbft will panic
+	// otherwise. It's called once per height.
+	ValidatorWeights func(validators []PublicKey) []uint64
+	// RTTEstimator collects round-trip time measurements used for timer
+	// adjustments. It's RTTStats with DefaultRTTWindow by default.
+	RTTEstimator RTTEstimator
+	// MaxClockSkew, if set, is the maximum allowed clock offset of other
+	// validators estimated from message timestamps. Exceeding it is logged
+	// and reported via OnClockSkew.
+	MaxClockSkew time.Duration
+	// OnClockSkew is called when the clock offset of the validator with the
+	// given index exceeds MaxClockSkew, it's called again only after the
+	// offset gets back within the limit and exceeds it once more.
+	OnClockSkew func(validator int, offset time.Duration)
+	// MaxTimestampDrift, if set, makes backups reject PrepareRequest with a
+	// timestamp exceeding both local time and the previous block timestamp
+	// by more than this value. It protects block time monotonicity from
+	// primaries with clocks running ahead.
+	MaxTimestampDrift time.Duration
+	// Pipelined enables pipelined mode: once a block is approved and passed
+	// to ProcessBlock, consensus for the next height starts immediately on
+	// top of it without waiting for Reset. ProcessBlock is expected to only
+	// queue the block for persistence then. Reset called after persistence
+	// does nothing unless the persisted block differs from the approved one,
+	// if persistence fails Rollback must be called. RecoveryRequests for the
+	// height of the approved block are answered until it's persisted. The
+	// next block is proposed and prepared, but not signed (neither with
+	// Commit nor with fast path preparation signature) until the approved
+	// one is persisted, since Rollback drops the state of the next height.
+	Pipelined bool
+	// Observer enables observer mode for nodes that follow consensus
+	// without taking part in it (like indexers). Observer never signs or
+	// sends anything (GetKeyPair is not used and may be omitted), it
+	// reconstructs accepted blocks from received payloads (including
+	// RecoveryMessages) and passes them to ProcessBlock along with
+	// FinalityProof if ProcessFinalityProof is set. The next height is
+	// started on top of the accepted block right away, so ledger callbacks
+	// (CurrentHeight, CurrentBlockHash) are only used to get the initial
+	// state by Start and Reset.
+	Observer bool
+	// Reputation, if set, is updated with validators' proposal liveness
+	// statistics of blocks accepted by dBFT. It can be used for primary
+	// selection via Reputation.PrimarySelector, but then the caller must
+	// also rebuild it from the ledger (see Reputation.AddBlock).
+	Reputation *Reputation
 	// NewConsensusPayload is a constructor for payload.ConsensusPayload.
 	NewConsensusPayload func(*Context[H], MessageType, any) ConsensusPayload[H]
 	// NewPrepareRequest is a constructor for payload.PrepareRequest.
@@ -86,7 +223,7 @@ type Config[H Hash] struct {
 	// NewPrepareResponse is a constructor for payload.PrepareResponse.
 	NewPrepareResponse func(preparationHash H) PrepareResponse[H]
 	// NewChangeView is a constructor for payload.ChangeView.
-	NewChangeView func(newViewNumber byte, reason ChangeViewReason, timestamp uint64) ChangeView
+	NewChangeView func(newViewNumber View, reason ChangeViewReason, timestamp uint64) ChangeView
 	// NewPreCommit is a constructor for payload.PreCommit.
 	NewPreCommit func(data []byte) PreCommit
 	// NewCommit is a constructor for payload.Commit.
@@ -107,6 +244,62 @@ type Config[H Hash] struct {
 	// Note that Block-dependent Commit verification should be performed inside Block.Verify
 	// callback.
 	VerifyCommit func(p ConsensusPayload[H]) error
+	// VerifyAsync enables asynchronous verification mode if set. VerifyBlock,
+	// VerifyPreBlock and payload Verify* callbacks are not called by dBFT
+	// directly then, instead verification tasks are passed to VerifyAsync
+	// which is expected to run them in a separate worker and deliver results
+	// via DBFT.OnVerificationResult. Payloads are processed only after their
+	// verification is completed. Verification callbacks must be safe for
+	// concurrent use in this mode, blocks and payloads passed to them are
+	// shared with dBFT and must not be modified.
+	VerifyAsync func(t *VerificationTask[H])
+	// RecoveryVerificationWorkers is the number of goroutines used to verify
+	// Commit signatures and PreCommit data extracted from RecoveryMessage
+	// before applying them. It's 1 by default (sequential verification),
+	// values greater than 1 (like runtime.GOMAXPROCS) can only be used if
+	// Block.Verify and PreBlock.Verify are safe for concurrent use. It's not
+	// used if Block (PreBlock) implements BatchVerifier.
+	RecoveryVerificationWorkers int
+	// MaxSeenPayloads is the number of recently processed payload hashes
+	// kept to drop duplicated payloads before processing, duplicates are
+	// counted per validator (see DBFT.Duplicates). Hashes are forgotten when
+	// the next height is started. It's DefaultMaxSeenPayloads by default,
+	// zero disables deduplication.
+	MaxSeenPayloads int
+	// ChangeViewRateLimit limits the number of ChangeView payloads handled
+	// per validator, excessive payloads are dropped. Tokens are only spent on
+	// payloads that are accepted or answered with RecoveryMessage, ignored
+	// ones (outdated, cached for the future heights and so on) are free.
+	// Notice that dropping ChangeView of an honest validator delays view
+	// change until the validator re-sends it on the next timeout or it's
+	// received via RecoveryMessage (which is not limited), so the limit
+	// should allow at least one ChangeView per view timeout. It doesn't
+	// limit anything by default.
+	ChangeViewRateLimit RateLimit
+	// RecoveryRequestRateLimit limits the number of RecoveryRequest payloads
+	// handled per validator, excessive payloads are dropped. Tokens are only
+	// spent on payloads answered with RecoveryMessage. It doesn't limit
+	// anything by default.
+	RecoveryRequestRateLimit RateLimit
+	// RecoveryResponseRateLimit limits the number of RecoveryMessages sent in
+	// response to ChangeView or RecoveryRequest payloads of every validator.
+	// It doesn't limit anything by default.
+	RecoveryResponseRateLimit RateLimit
+	// OnRateLimited, if set, is called every time a payload of the given type
+	// from the validator with the given index is dropped due to rate limits.
+	// RecoveryMessageType is used for suppressed recovery responses.
+	OnRateLimited func(validator int, t MessageType)
+	// VerifyChangeView performs external ChangeView verification and returns
+	// nil if it's successful.
+	VerifyChangeView func(p ConsensusPayload[H]) error
+	// VerifyRecoveryRequest performs external RecoveryRequest verification
+	// and returns nil if it's successful.
+	VerifyRecoveryRequest func(p ConsensusPayload[H]) error
+	// VerifyRecoveryMessage performs external RecoveryMessage verification
+	// and returns nil if it's successful. Payloads extracted from the
+	// RecoveryMessage are checked with the corresponding Verify* callbacks
+	// afterwards, DBFT.RecoverySource returns the RecoveryMessage then.
+	VerifyRecoveryMessage func(p ConsensusPayload[H]) error
 }
 
 const defaultSecondsPerBlock = time.Second * 15
@@ -119,6 +312,9 @@ func defaultConfig[H Hash]() *Config[H] {
 		Logger:             zap.NewNop(),
 		TimePerBlock:       func() time.Duration { return defaultSecondsPerBlock },
 		TimestampIncrement: defaultTimestampIncrement,
+		MaxViewNumber:      DefaultMaxViewNumber,
+		TimeoutPolicy:      DefaultTimeoutPolicy,
+		RTTEstimator:       NewRTTStats(DefaultRTTWindow),
 		GetKeyPair:         nil,
 		RequestTx:          func(...H) {},
 		StopTxFlow:         func() {},
@@ -132,24 +328,44 @@ func defaultConfig[H Hash]() *Config[H] {
 		CurrentHeight:      nil,
 		CurrentBlockHash:   nil,
 		GetValidators:      nil,
+		PrimarySelector:    DefaultPrimarySelector,
+
+		RecoveryVerificationWorkers: 1,
+		MaxSeenPayloads:             DefaultMaxSeenPayloads,
 
 		VerifyPrepareRequest:  func(ConsensusPayload[H]) error { return nil },
 		VerifyPrepareResponse: func(ConsensusPayload[H]) error { return nil },
 		VerifyCommit:          func(ConsensusPayload[H]) error { return nil },
+		VerifyChangeView:      func(ConsensusPayload[H]) error { return nil },
+		VerifyRecoveryRequest: func(ConsensusPayload[H]) error { return nil },
+		VerifyRecoveryMessage: func(ConsensusPayload[H]) error { return nil },
 
 		AntiMEVExtensionEnablingHeight: -1,
+		FastPathEnablingHeight:         -1,
 		VerifyPreBlock:                 func(PreBlock[H]) bool { return true },
 		VerifyPreCommit:                func(ConsensusPayload[H]) error { return nil },
 	}
 }
 
 func checkConfig[H Hash](cfg *Config[H]) error {
-	if cfg.GetKeyPair == nil {
+	if cfg.GetKeyPair == nil && !cfg.Observer {
 		return errors.New("private key is nil")
 	}
 	if cfg.Timer == nil {
 		return errors.New("Timer is nil")
 	}
+	if cfg.MaxViewNumber == 0 {
+		return errors.New("MaxViewNumber is zero")
+	}
+	if _, ok := cfg.Timer.(byteViewTimer); ok && cfg.MaxViewNumber > DefaultMaxViewNumber {
+		return errors.New("MaxViewNumber exceeds ByteViewTimer capacity")
+	}
+	if cfg.TimeoutPolicy == nil {
+		return errors.New("TimeoutPolicy is nil")
+	}
+	if cfg.RTTEstimator == nil {
+		return errors.New("RTTEstimator is nil")
+	}
 	if cfg.CurrentHeight == nil {
 		return errors.New("CurrentHeight is nil")
 	}
@@ -159,6 +375,9 @@ func checkConfig[H Hash](cfg *Config[H]) error {
 	if cfg.GetValidators == nil {
 		return errors.New("GetValidators is nil")
 	}
+	if cfg.PrimarySelector == nil {
+		return errors.New("PrimarySelector is nil")
+	}
 	if cfg.NewBlockFromContext == nil {
 		return errors.New("NewBlockFromContext is nil")
 	}
@@ -204,6 +423,29 @@ func checkConfig[H Hash](cfg *Config[H]) error {
 			return errors.New("NewPreCommit is set, but AntiMEVExtensionEnablingHeight is not specified")
 		}
 	}
+	if cfg.TxRetryInterval < 0 || cfg.MaxTxRetryInterval < cfg.TxRetryInterval {
+		return errors.New("invalid transaction retry intervals")
+	}
+	if cfg.MaxProposalTransactions < 0 || cfg.MaxProposalSize < 0 {
+		return errors.New("negative proposal limits")
+	}
+	for _, l := range []RateLimit{cfg.ChangeViewRateLimit, cfg.RecoveryRequestRateLimit, cfg.RecoveryResponseRateLimit} {
+		if l.Rate < 0 || l.Burst < 0 {
+			return errors.New("negative rate limit")
+		}
+	}
+	if cfg.MaxSeenPayloads < 0 {
+		return errors.New("negative MaxSeenPayloads")
+	}
+	if cfg.RecoveryVerificationWorkers < 1 {
+		return errors.New("RecoveryVerificationWorkers must be positive")
+	}
+	if cfg.MaxProposalWeight != 0 && cfg.TransactionWeight == nil {
+		return errors.New("MaxProposalWeight is set, but TransactionWeight is nil")
+	}
+	if cfg.ProcessFinalityProof != nil && cfg.ValidatorsHash == nil {
+		return errors.New("ProcessFinalityProof is set, but ValidatorsHash is nil")
+	}
 	if (cfg.MaxTimePerBlock == nil) != (cfg.SubscribeForTxs == nil) {
 		return errors.New("MaxTimePerBlock and SubscribeForTxs should be specified/not specified at the same time")
 	}
@@ -253,6 +495,27 @@ func WithAntiMEVExtensionEnablingHeight[H Hash](h int64) func(config *Config[H])
 	}
 }
 
+// WithFastPathEnablingHeight sets FastPathEnablingHeight.
+func WithFastPathEnablingHeight[H Hash](h int64) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.FastPathEnablingHeight = h
+	}
+}
+
+// WithMaxViewNumber sets MaxViewNumber.
+func WithMaxViewNumber[H Hash](v View) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.MaxViewNumber = v
+	}
+}
+
+// WithTimeoutPolicy sets TimeoutPolicy.
+func WithTimeoutPolicy[H Hash](p TimeoutPolicy) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.TimeoutPolicy = p
+	}
+}
+
 // WithTimestampIncrement sets TimestampIncrement.
 func WithTimestampIncrement[H Hash](u uint64) func(config *Config[H]) {
 	return func(cfg *Config[H]) {
@@ -281,6 +544,28 @@ func WithRequestTx[H Hash](f func(h ...H)) func(config *Config[H]) {
 	}
 }
 
+// WithRequestTxFrom sets RequestTxFrom.
+func WithRequestTxFrom[H Hash](f func(validator int, h ...H)) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.RequestTxFrom = f
+	}
+}
+
+// WithTxRetry sets TxRetryInterval and MaxTxRetryInterval.
+func WithTxRetry[H Hash](interval, maxInterval time.Duration) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.TxRetryInterval = interval
+		cfg.MaxTxRetryInterval = maxInterval
+	}
+}
+
+// WithOnTxFetched sets OnTxFetched.
+func WithOnTxFetched[H Hash](f func(h H, latency time.Duration, attempts int)) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.OnTxFetched = f
+	}
+}
+
 // WithSubscribeForTxs sets SubscribeForTxs.
 func WithSubscribeForTxs[H Hash](f func()) func(config *Config[H]) {
 	return func(cfg *Config[H]) {
@@ -309,6 +594,42 @@ func WithGetVerified[H Hash](f func() []Transaction[H]) func(config *Config[H])
 	}
 }
 
+// WithVerifyTransaction sets VerifyTransaction.
+func WithVerifyTransaction[H Hash](f func(tx Transaction[H]) error) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.VerifyTransaction = f
+	}
+}
+
+// WithOnTxRejected sets OnTxRejected.
+func WithOnTxRejected[H Hash](f func(h H, err error)) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.OnTxRejected = f
+	}
+}
+
+// WithMaxProposalTransactions sets MaxProposalTransactions.
+func WithMaxProposalTransactions[H Hash](n int) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.MaxProposalTransactions = n
+	}
+}
+
+// WithMaxProposalSize sets MaxProposalSize.
+func WithMaxProposalSize[H Hash](n int) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.MaxProposalSize = n
+	}
+}
+
+// WithMaxProposalWeight sets MaxProposalWeight and TransactionWeight.
+func WithMaxProposalWeight[H Hash](w uint64, f func(tx Transaction[H]) uint64) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.MaxProposalWeight = w
+		cfg.TransactionWeight = f
+	}
+}
+
 // WithVerifyPreBlock sets VerifyPreBlock.
 func WithVerifyPreBlock[H Hash](f func(b PreBlock[H]) bool) func(config *Config[H]) {
 	return func(cfg *Config[H]) {
@@ -330,6 +651,13 @@ func WithBroadcast[H Hash](f func(m ConsensusPayload[H])) func(config *Config[H]
 	}
 }
 
+// WithSendTo sets SendTo.
+func WithSendTo[H Hash](f func(validator int, m ConsensusPayload[H])) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.SendTo = f
+	}
+}
+
 // WithProcessBlock sets ProcessBlock callback. Note that for anti-MEV extension
 // disabled non-nil error return is a no-op.
 func WithProcessBlock[H Hash](f func(b Block[H]) error) func(config *Config[H]) {
@@ -338,6 +666,20 @@ func WithProcessBlock[H Hash](f func(b Block[H]) error) func(config *Config[H])
 	}
 }
 
+// WithProcessFinalityProof sets ProcessFinalityProof.
+func WithProcessFinalityProof[H Hash](f func(p *FinalityProof[H])) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.ProcessFinalityProof = f
+	}
+}
+
+// WithValidatorsHash sets ValidatorsHash.
+func WithValidatorsHash[H Hash](f func(validators []PublicKey) H) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.ValidatorsHash = f
+	}
+}
+
 // WithProcessPreBlock sets ProcessPreBlock.
 func WithProcessPreBlock[H Hash](f func(b PreBlock[H]) error) func(config *Config[H]) {
 	return func(cfg *Config[H]) {
@@ -380,6 +722,69 @@ func WithGetValidators[H Hash](f func(txs ...Transaction[H]) []PublicKey) func(c
 	}
 }
 
+// WithPrimarySelector sets PrimarySelector.
+func WithPrimarySelector[H Hash](f func(height uint32, view View, validators []PublicKey) uint) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.PrimarySelector = f
+	}
+}
+
+// WithValidatorWeights sets ValidatorWeights.
+func WithValidatorWeights[H Hash](f func(validators []PublicKey) []uint64) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.ValidatorWeights = f
+	}
+}
+
+// WithRTTEstimator sets RTTEstimator.
+func WithRTTEstimator[H Hash](r RTTEstimator) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.RTTEstimator = r
+	}
+}
+
+// WithMaxClockSkew sets MaxClockSkew.
+func WithMaxClockSkew[H Hash](d time.Duration) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.MaxClockSkew = d
+	}
+}
+
+// WithOnClockSkew sets OnClockSkew.
+func WithOnClockSkew[H Hash](f func(validator int, offset time.Duration)) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.OnClockSkew = f
+	}
+}
+
+// WithMaxTimestampDrift sets MaxTimestampDrift.
+func WithMaxTimestampDrift[H Hash](d time.Duration) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.MaxTimestampDrift = d
+	}
+}
+
+// WithPipelining sets Pipelined.
+func WithPipelining[H Hash](enabled bool) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.Pipelined = enabled
+	}
+}
+
+// WithObserver sets Observer.
+func WithObserver[H Hash](enabled bool) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.Observer = enabled
+	}
+}
+
+// WithReputation sets Reputation.
+func WithReputation[H Hash](r *Reputation) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.Reputation = r
+	}
+}
+
 // WithNewConsensusPayload sets NewConsensusPayload.
 func WithNewConsensusPayload[H Hash](f func(ctx *Context[H], typ MessageType, msg any) ConsensusPayload[H]) func(config *Config[H]) {
 	return func(cfg *Config[H]) {
@@ -402,7 +807,7 @@ func WithNewPrepareResponse[H Hash](f func(preparationHash H) PrepareResponse[H]
 }
 
 // WithNewChangeView sets NewChangeView.
-func WithNewChangeView[H Hash](f func(newViewNumber byte, reason ChangeViewReason, ts uint64) ChangeView) func(config *Config[H]) {
+func WithNewChangeView[H Hash](f func(newViewNumber View, reason ChangeViewReason, ts uint64) ChangeView) func(config *Config[H]) {
 	return func(cfg *Config[H]) {
 		cfg.NewChangeView = f
 	}
@@ -463,3 +868,73 @@ func WithVerifyCommit[H Hash](f func(commit ConsensusPayload[H]) error) func(con
 		cfg.VerifyCommit = f
 	}
 }
+
+// WithVerifyChangeView sets VerifyChangeView.
+func WithVerifyChangeView[H Hash](f func(changeView ConsensusPayload[H]) error) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.VerifyChangeView = f
+	}
+}
+
+// WithVerifyRecoveryRequest sets VerifyRecoveryRequest.
+func WithVerifyRecoveryRequest[H Hash](f func(recoveryReq ConsensusPayload[H]) error) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.VerifyRecoveryRequest = f
+	}
+}
+
+// WithVerifyRecoveryMessage sets VerifyRecoveryMessage.
+func WithVerifyRecoveryMessage[H Hash](f func(recoveryMsg ConsensusPayload[H]) error) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.VerifyRecoveryMessage = f
+	}
+}
+
+// WithVerifyAsync sets VerifyAsync.
+func WithVerifyAsync[H Hash](f func(t *VerificationTask[H])) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.VerifyAsync = f
+	}
+}
+
+// WithRecoveryVerificationWorkers sets RecoveryVerificationWorkers.
+func WithRecoveryVerificationWorkers[H Hash](n int) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.RecoveryVerificationWorkers = n
+	}
+}
+
+// WithMaxSeenPayloads sets MaxSeenPayloads.
+func WithMaxSeenPayloads[H Hash](n int) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.MaxSeenPayloads = n
+	}
+}
+
+// WithChangeViewRateLimit sets ChangeViewRateLimit.
+func WithChangeViewRateLimit[H Hash](l RateLimit) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.ChangeViewRateLimit = l
+	}
+}
+
+// WithRecoveryRequestRateLimit sets RecoveryRequestRateLimit.
+func WithRecoveryRequestRateLimit[H Hash](l RateLimit) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.RecoveryRequestRateLimit = l
+	}
+}
+
+// WithRecoveryResponseRateLimit sets RecoveryResponseRateLimit.
+func WithRecoveryResponseRateLimit[H Hash](l RateLimit) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.RecoveryResponseRateLimit = l
+	}
+}
+
+// WithOnRateLimited sets OnRateLimited.
+func WithOnRateLimited[H Hash](f func(validator int, t MessageType)) func(config *Config[H]) {
+	return func(cfg *Config[H]) {
+		cfg.OnRateLimited = f
+	}
+}
@@ -3,7 +3,7 @@ package dbft
 // ConsensusMessage is an interface for generic dBFT message.
 type ConsensusMessage[H Hash] interface {
 	// ViewNumber returns view number when this message was originated.
-	ViewNumber() byte
+	ViewNumber() View
 	// Type returns type of this message.
 	Type() MessageType
 	// Payload returns this message's actual payload.
@@ -3,13 +3,17 @@ package dbft
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"fmt"
+	"math"
 	"time"
+
+	"go.uber.org/zap"
 )
 
 // HeightView is a block height/consensus view pair.
 type HeightView struct {
 	Height uint32
-	View   byte
+	View   View
 }
 
 // Context is a main dBFT structure which
@@ -36,13 +40,28 @@ type Context[H Hash] struct {
 	// invoked for the current height. This happens once and dbft continues
 	// to march towards proper commit after that.
 	preBlockProcessed bool
+	// pendingBlock is the block approved, but not yet persisted in
+	// pipelined mode (see Config.Pipelined).
+	pendingBlock Block[H]
+	// pendingContext is the state of pendingBlock height, it's used to
+	// answer RecoveryRequests for this height and to restore it on
+	// Rollback.
+	pendingContext *Context[H]
 
 	// BlockIndex is current block index.
 	BlockIndex uint32
 	// ViewNumber is current view number.
-	ViewNumber byte
+	ViewNumber View
 	// Validators is a current validator list.
 	Validators []PublicKey
+	// NextValidators is a validator list for the next block computed via
+	// Config.GetValidators from the proposed block transactions. It's nil
+	// until all proposed transactions are collected and the header is
+	// constructed. Config.NewBlockFromContext is expected to commit to this
+	// list in the header (like NextConsensus field of Neo blocks do), this
+	// way nodes that compute a different list can't sign the same header.
+	// Headers implementing NextConsensusBlock are checked against it.
+	NextValidators []PublicKey
 	// MyIndex is an index of the current node in the Validators array.
 	// It is equal to -1 if node is not a validator or is WatchOnly.
 	MyIndex int
@@ -57,8 +76,8 @@ type Context[H Hash] struct {
 	Nonce     uint64
 	// TransactionHashes is a slice of hashes of proposed transactions in the current block.
 	TransactionHashes []H
-	// MissingTransactions is a slice of hashes containing missing transactions for the current block.
-	MissingTransactions []H
+	// MissingTransactions is a set of hashes of missing transactions for the current block.
+	MissingTransactions map[H]struct{}
 	// Transactions is a map containing actual transactions for the current block.
 	Transactions map[H]Transaction[H]
 
@@ -86,36 +105,160 @@ type Context[H Hash] struct {
 	// LastSeenMessage array stores the height and view of the last seen message, for each validator.
 	// If this node never heard a thing from validator i, LastSeenMessage[i] will be nil.
 	LastSeenMessage []*HeightView
+	// FastPathApproved is true if the block of the current height was
+	// approved via fast path (see Config.FastPathEnablingHeight). There may
+	// be not enough CommitPayloads to construct block witness in this case
+	// and preparation signatures are not valid block signatures (see
+	// PreparationSigner), so FinalityProof (a fast path one) must be used
+	// as the block certificate instead.
+	FastPathApproved bool
 
 	lastBlockTimestamp uint64    // ns-precision timestamp from the last header (used for the next block timestamp calculations).
 	lastBlockTime      time.Time // Wall clock time of when we started (as in PrepareRequest) creating the last block (used for timer adjustments).
 	lastBlockIndex     uint32
-	lastBlockView      byte
+	lastBlockView      View
 	timePerBlock       time.Duration // minimum amount of time that need to pass before the pending block will be accepted if there are some transactions in the proposal.
 	maxTimePerBlock    time.Duration // maximum amount of time that allowed to pass before the pending block will be accepted even if there's no transactions in the proposal.
 	txSubscriptionOn   bool
 
-	prepareSentTime time.Time
-	rttEstimates    rtt
+	prepareSentTime     time.Time
+	prepareReceivedTime time.Time
+
+	clocks validatorState[clockState]
+
+	// weights are voting weights of Validators, nil means equal weights.
+	weights     []int
+	totalWeight int
+
+	counters payloadCounters
 }
 
 // N returns total number of validators.
 func (c *Context[H]) N() int { return len(c.Validators) }
 
-// F returns number of validators which can be faulty.
-func (c *Context[H]) F() int { return (len(c.Validators) - 1) / 3 }
+// F returns number of validators which can be faulty. If validators have
+// different weights (see Config.ValidatorWeights), it returns the maximum
+// weight of faulty validators.
+func (c *Context[H]) F() int { return (c.TotalWeight() - 1) / 3 }
+
+// M returns number of validators which must function correctly. If validators
+// have different weights (see Config.ValidatorWeights), it returns the weight
+// required for quorum, which is more than 2/3 of the total weight.
+func (c *Context[H]) M() int { return c.TotalWeight() - c.F() }
+
+// viewTimeout returns view change timeout for the given view.
+func (c *Context[H]) viewTimeout(view View) time.Duration {
+	return c.Config.TimeoutPolicy.ViewTimeout(c.timeoutParams(view))
+}
+
+// extendedViewTimeout returns the time backup waits for transactions in
+// view 0 if MaxTimePerBlock is set in addition to the regular view timeout.
+func (c *Context[H]) extendedViewTimeout() time.Duration {
+	p := c.timeoutParams(0)
+	p.TimePerBlock = c.maxTimePerBlock
+	return max(c.Config.TimeoutPolicy.ViewTimeout(p)-c.viewTimeout(0), 0)
+}
+
+// commitTimeout returns Commit resend timeout for the current view.
+func (c *Context[H]) commitTimeout(retry bool) time.Duration {
+	p := c.timeoutParams(c.ViewNumber)
+	p.Retry = retry
+	return c.Config.TimeoutPolicy.CommitTimeout(p)
+}
+
+func (c *Context[H]) timeoutParams(view View) TimeoutParams {
+	return TimeoutParams{
+		TimePerBlock: c.timePerBlock,
+		View:         view,
+		RTT:          c.averageRTT(),
+	}
+}
+
+// averageRTT returns average round-trip time measured by this node in its
+// current role: PrepareRTT for primary and CommitRTT for backups. The other
+// kind is used if the preferred one is not yet measured.
+func (c *Context[H]) averageRTT() time.Duration {
+	var kinds = [2]RTTKind{CommitRTT, PrepareRTT}
+	if c.IsPrimary() {
+		kinds[0], kinds[1] = kinds[1], kinds[0]
+	}
+	for _, k := range kinds {
+		if rtt := c.Config.RTTEstimator.Average(k); rtt != 0 {
+			return rtt
+		}
+	}
+	return 0
+}
+
+// Weight returns voting weight of the validator with the given index. It's 1
+// for all validators unless Config.ValidatorWeights is set.
+func (c *Context[H]) Weight(i int) int {
+	if c.weights == nil {
+		return 1
+	}
+	return c.weights[i]
+}
+
+// TotalWeight returns total voting weight of validators. It's equal to N
+// unless Config.ValidatorWeights is set.
+func (c *Context[H]) TotalWeight() int {
+	if c.weights == nil {
+		return len(c.Validators)
+	}
+	return c.totalWeight
+}
 
-// M returns number of validators which must function correctly.
-func (c *Context[H]) M() int { return len(c.Validators) - c.F() }
+func (c *Context[H]) updateWeights() {
+	if c.Config.ValidatorWeights == nil {
+		c.weights = nil
+		return
+	}
+	ws := c.Config.ValidatorWeights(c.Validators)
+	if len(ws) != len(c.Validators) {
+		panic("validator weights don't match validators")
+	}
+	c.weights = emptyReusableSlice(c.weights, len(ws))
+	c.totalWeight = 0
+	for i, w := range ws {
+		if w > math.MaxInt32 {
+			panic("validator weight is too big")
+		}
+		c.weights[i] = int(w)
+		c.totalWeight += int(w)
+	}
+	if c.totalWeight == 0 {
+		panic("total validators weight is zero")
+	}
+}
 
 // GetPrimaryIndex returns index of a primary node for the specified view.
-func (c *Context[H]) GetPrimaryIndex(viewNumber byte) uint {
-	p := (int(c.BlockIndex) - int(viewNumber)) % len(c.Validators)
+// It uses Config.PrimarySelector for the current height and validators,
+// DefaultPrimarySelector is used if the selector returns an out of range
+// index.
+func (c *Context[H]) GetPrimaryIndex(viewNumber View) uint {
+	p := c.Config.PrimarySelector(c.BlockIndex, viewNumber, c.Validators)
+	if p < uint(len(c.Validators)) {
+		return p
+	}
+
+	c.Config.Logger.Error("primary index is out of range, using default selector",
+		zap.Uint32("height", c.BlockIndex),
+		zap.Uint("view", uint(viewNumber)),
+		zap.Uint("index", p),
+		zap.Int("validators", len(c.Validators)))
+
+	return DefaultPrimarySelector(c.BlockIndex, viewNumber, c.Validators)
+}
+
+// DefaultPrimarySelector is a standard dBFT round-robin primary selection
+// strategy, primary index is (height - view) mod N.
+func DefaultPrimarySelector(height uint32, view View, validators []PublicKey) uint {
+	p := (int(height) - int(view)) % len(validators)
 	if p >= 0 {
 		return uint(p)
 	}
 
-	return uint(p + len(c.Validators))
+	return uint(p + len(validators))
 }
 
 // IsPrimary returns true iff node is primary for current height and view.
@@ -131,25 +274,21 @@ func (c *Context[H]) WatchOnly() bool { return c.MyIndex < 0 || c.Config.WatchOn
 
 // CountCommitted returns number of received Commit (or PreCommit for anti-MEV
 // extension) messages not only for the current epoch but also for any other epoch.
-func (c *Context[H]) CountCommitted() (count int) {
-	for i := range c.CommitPayloads {
-		// Consider both Commit and PreCommit payloads since both Commit and PreCommit
-		// phases are one-directional (do not impose view change).
-		if c.CommitPayloads[i] != nil || c.PreCommitPayloads[i] != nil {
-			count++
-		}
-	}
-
-	return
+// If Config.ValidatorWeights is set, total weight of their senders is returned.
+func (c *Context[H]) CountCommitted() int {
+	// Consider both Commit and PreCommit payloads since both Commit and PreCommit
+	// phases are one-directional (do not impose view change).
+	return c.counters.committed
 }
 
 // CountFailed returns number of nodes with which no communication was performed
 // for this view and that hasn't sent the Commit message at the previous views.
+// If Config.ValidatorWeights is set, total weight of these nodes is returned.
 func (c *Context[H]) CountFailed() (count int) {
 	for i, hv := range c.LastSeenMessage {
-		if (c.CommitPayloads[i] == nil && c.PreCommitPayloads[i] == nil) &&
+		if !c.isCommitted(i) &&
 			(hv == nil || hv.Height < c.BlockIndex || hv.View < c.ViewNumber) {
-			count++
+			count += c.Weight(i)
 		}
 	}
 
@@ -240,16 +379,40 @@ func (c *Context[H]) PreBlock() PreBlock[H] {
 	return c.preBlock
 }
 
-func (c *Context[H]) reset(view byte, ts uint64) {
+func (c *Context[H]) reset(view View, ts uint64) {
 	c.MyIndex = -1
 	c.prepareSentTime = time.Time{}
+	c.prepareReceivedTime = time.Time{}
 	c.lastBlockTimestamp = ts
 	c.unsubscribeFromTransactions()
 
 	if view == 0 {
-		c.PrevHash = c.Config.CurrentBlockHash()
-		c.BlockIndex = c.Config.CurrentHeight() + 1
-		c.Validators = c.Config.GetValidators()
+		var (
+			height   = c.Config.CurrentHeight() + 1
+			prevHash H
+		)
+		if c.pendingBlock != nil && c.pendingBlock.Index() < height {
+			// Already persisted.
+			c.pendingBlock, c.pendingContext = nil, nil
+		}
+		if c.pendingBlock != nil && c.pendingBlock.Index() >= height {
+			// Pipelined or observer mode, the block is approved, but not yet
+			// persisted.
+			prevHash = c.pendingBlock.Hash()
+			height = c.pendingBlock.Index() + 1
+		} else {
+			prevHash = c.Config.CurrentBlockHash()
+		}
+		if c.blockProcessed && c.BlockIndex+1 == height && c.NextValidators != nil {
+			// The previous block was accepted by us, so hand over to the
+			// committee it defines.
+			c.Validators = c.NextValidators
+		} else {
+			c.Validators = c.Config.GetValidators()
+		}
+		c.updateWeights()
+		c.PrevHash = prevHash
+		c.BlockIndex = height
 		c.timePerBlock = c.Config.TimePerBlock()
 		if c.Config.MaxTimePerBlock != nil {
 			c.maxTimePerBlock = c.Config.MaxTimePerBlock()
@@ -259,8 +422,10 @@ func (c *Context[H]) reset(view byte, ts uint64) {
 		c.LastChangeViewPayloads = emptyReusableSlice(c.LastChangeViewPayloads, n)
 
 		c.LastSeenMessage = emptyReusableSlice(c.LastSeenMessage, n)
+		c.clocks.retain(c.Validators)
 		c.blockProcessed = false
 		c.preBlockProcessed = false
+		c.FastPathApproved = false
 	} else {
 		for i := range c.Validators {
 			m := c.ChangeViewPayloads[i]
@@ -272,12 +437,17 @@ func (c *Context[H]) reset(view byte, ts uint64) {
 		}
 	}
 
-	c.MyIndex, c.Priv, c.Pub = c.Config.GetKeyPair(c.Validators)
+	if c.Config.Observer {
+		c.MyIndex, c.Priv, c.Pub = -1, nil, nil
+	} else {
+		c.MyIndex, c.Priv, c.Pub = c.Config.GetKeyPair(c.Validators)
+	}
 
 	c.block = nil
 	c.preBlock = nil
 	c.header = nil
 	c.preHeader = nil
+	c.NextValidators = nil
 
 	n := len(c.Validators)
 	c.ChangeViewPayloads = emptyReusableSlice(c.ChangeViewPayloads, n)
@@ -286,6 +456,7 @@ func (c *Context[H]) reset(view byte, ts uint64) {
 		c.CommitPayloads = emptyReusableSlice(c.CommitPayloads, n)
 	}
 	c.PreparationPayloads = emptyReusableSlice(c.PreparationPayloads, n)
+	c.resetCounters(view, n)
 
 	if c.Transactions == nil { // Init.
 		c.Transactions = make(map[H]Transaction[H])
@@ -293,8 +464,10 @@ func (c *Context[H]) reset(view byte, ts uint64) {
 		clear(c.Transactions)
 	}
 	c.TransactionHashes = nil
-	if c.MissingTransactions != nil {
-		c.MissingTransactions = c.MissingTransactions[:0]
+	if c.MissingTransactions == nil {
+		c.MissingTransactions = make(map[H]struct{})
+	} else {
+		clear(c.MissingTransactions)
 	}
 	c.PrimaryIndex = c.GetPrimaryIndex(view)
 	c.ViewNumber = view
@@ -304,6 +477,20 @@ func (c *Context[H]) reset(view byte, ts uint64) {
 	}
 }
 
+// detach returns a copy of the context and makes the context drop references
+// to the slices and maps it shares with the copy, so that the copy is not
+// affected by subsequent reset.
+func (c *Context[H]) detach() *Context[H] {
+	saved := *c
+	c.PreparationPayloads, c.PreCommitPayloads, c.CommitPayloads = nil, nil, nil
+	c.ChangeViewPayloads, c.LastChangeViewPayloads = nil, nil
+	c.LastSeenMessage = nil
+	c.Transactions, c.MissingTransactions = nil, nil
+	c.weights = nil
+	c.counters = payloadCounters{}
+	return &saved
+}
+
 func emptyReusableSlice[E any](s []E, n int) []E {
 	if len(s) == n {
 		clear(s)
@@ -325,11 +512,18 @@ func (c *Context[H]) Fill(force bool) bool {
 	_, _ = rand.Read(b)
 
 	c.Nonce = binary.LittleEndian.Uint64(b)
-	c.TransactionHashes = make([]H, len(txx))
+	c.TransactionHashes = make([]H, 0, len(txx))
 
+	var l = proposalLimits[H]{cfg: c.Config}
 	for i := range txx {
 		h := txx[i].Hash()
-		c.TransactionHashes[i] = h
+		if _, ok := c.Transactions[h]; ok {
+			continue
+		}
+		if !l.add(txx[i]) {
+			break
+		}
+		c.TransactionHashes = append(c.TransactionHashes, h)
 		c.Transactions[h] = txx[i]
 	}
 
@@ -353,11 +547,7 @@ func (c *Context[H]) CreateBlock() Block[H] {
 			return nil
 		}
 
-		txx := make([]Transaction[H], len(c.TransactionHashes))
-
-		for i, h := range c.TransactionHashes {
-			txx[i] = c.Transactions[h]
-		}
+		txx := c.proposedTransactions()
 
 		// Anti-MEV extension properly sets PreBlock transactions once during PreBlock
 		// construction and then never updates these transactions in the dBFT context.
@@ -377,13 +567,7 @@ func (c *Context[H]) CreatePreBlock() PreBlock[H] {
 			return nil
 		}
 
-		txx := make([]Transaction[H], len(c.TransactionHashes))
-
-		for i, h := range c.TransactionHashes {
-			txx[i] = c.Transactions[h]
-		}
-
-		c.preBlock.SetTransactions(txx)
+		c.preBlock.SetTransactions(c.proposedTransactions())
 	}
 
 	return c.preBlock
@@ -395,11 +579,57 @@ func (c *Context[H]) isAntiMEVExtensionEnabled() bool {
 	return c.Config.AntiMEVExtensionEnablingHeight >= 0 && uint32(c.Config.AntiMEVExtensionEnablingHeight) <= c.BlockIndex
 }
 
+// isFastPathEnabled returns whether fast path is enabled at the currently
+// processing block height.
+func (c *Context[H]) isFastPathEnabled() bool {
+	return c.Config.FastPathEnablingHeight >= 0 && uint32(c.Config.FastPathEnablingHeight) <= c.BlockIndex &&
+		!c.isAntiMEVExtensionEnabled()
+}
+
+// fastPathLocked returns whether the node must not send ChangeView since it
+// has sent preparation at the height with fast path enabled. The lock is
+// released once more than F validators request view change: the first honest
+// one of them had no preparation sent at the moment and it won't send it
+// after ChangeView, so fast path can't succeed in this view.
+func (c *Context[H]) fastPathLocked() bool {
+	if !c.isFastPathEnabled() || !c.ResponseSent() {
+		return false
+	}
+
+	return c.changeViewWeight(c.ViewNumber+1) <= c.F()
+}
+
+// proposedTransactions returns a list of proposed transactions in the proposal
+// order. It's only valid to call it when all transactions are collected.
+func (c *Context[H]) proposedTransactions() []Transaction[H] {
+	txx := make([]Transaction[H], len(c.TransactionHashes))
+
+	for i, h := range c.TransactionHashes {
+		txx[i] = c.Transactions[h]
+	}
+	return txx
+}
+
+// checkNextValidators checks that b commits to NextValidators if it
+// implements NextConsensusBlock and Config.ValidatorsHash is set.
+func (c *Context[H]) checkNextValidators(b Block[H]) error {
+	nb, ok := b.(NextConsensusBlock[H])
+	if !ok || c.Config.ValidatorsHash == nil {
+		return nil
+	}
+	if h := c.Config.ValidatorsHash(c.NextValidators); nb.NextConsensus() != h {
+		return fmt.Errorf("next validators mismatch: header has %s, computed %s", nb.NextConsensus(), h)
+	}
+	return nil
+}
+
 // MakeHeader returns half-filled block for the current epoch.
-// All hashable fields will be filled.
+// All hashable fields will be filled. Header can't be constructed until all
+// proposed transactions are collected since they're needed to compute
+// NextValidators.
 func (c *Context[H]) MakeHeader() Block[H] {
 	if c.header == nil {
-		if !c.RequestSentOrReceived() {
+		if !c.RequestSentOrReceived() || !c.hasAllTransactions() {
 			return nil
 		}
 		// For anti-MEV dBFT extension it's important to have PreBlock processed and
@@ -410,6 +640,7 @@ func (c *Context[H]) MakeHeader() Block[H] {
 				return nil
 			}
 		}
+		c.NextValidators = c.Config.GetValidators(c.proposedTransactions()...)
 		c.header = c.Config.NewBlockFromContext(c)
 	}
 
@@ -429,8 +660,71 @@ func (c *Context[H]) MakePreHeader() PreBlock[H] {
 	return c.preHeader
 }
 
+// checkProposalLimits returns an error if proposed transactions exceed
+// limits set in the config. It's only valid to call it when all transactions
+// for this block are already collected.
+func (c *Context[H]) checkProposalLimits() error {
+	var l = proposalLimits[H]{cfg: c.Config}
+	for _, h := range c.TransactionHashes {
+		if !l.add(c.Transactions[h]) {
+			return fmt.Errorf("proposal exceeds limits at transaction %s", h)
+		}
+	}
+	return nil
+}
+
+// findDuplicate returns the first hash that is duplicated in hashes.
+func findDuplicate[H Hash](hashes []H) (H, bool) {
+	var set = make(map[H]struct{}, len(hashes))
+	for _, h := range hashes {
+		if _, ok := set[h]; ok {
+			return h, true
+		}
+		set[h] = struct{}{}
+	}
+	var h H
+	return h, false
+}
+
+// proposalLimits accumulates transactions count, size and weight to check
+// them against Config.MaxProposal* limits.
+type proposalLimits[H Hash] struct {
+	cfg    *Config[H]
+	count  int
+	size   int
+	weight uint64
+}
+
+// add accounts tx if it fits into the limits and returns false otherwise.
+func (l *proposalLimits[H]) add(tx Transaction[H]) bool {
+	var (
+		size   int
+		weight uint64
+	)
+	if l.cfg.MaxProposalTransactions > 0 && l.count >= l.cfg.MaxProposalTransactions {
+		return false
+	}
+	if st, ok := tx.(SizedTransaction[H]); ok && l.cfg.MaxProposalSize > 0 {
+		size = st.Size()
+		if l.size+size > l.cfg.MaxProposalSize {
+			return false
+		}
+	}
+	if l.cfg.MaxProposalWeight > 0 {
+		weight = l.cfg.TransactionWeight(tx)
+		if l.weight+weight > l.cfg.MaxProposalWeight || l.weight+weight < l.weight {
+			return false
+		}
+	}
+	l.count++
+	l.size += size
+	l.weight += weight
+	return true
+}
+
 // hasAllTransactions returns true iff all transactions were received
-// for the proposed block.
+// for the proposed block. Proposals with duplicated hashes are rejected, so
+// it's enough to compare lengths.
 func (c *Context[H]) hasAllTransactions() bool {
 	return len(c.TransactionHashes) == len(c.Transactions)
 }
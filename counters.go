@@ -0,0 +1,110 @@
+package dbft
+
+// payloadCounters maintains total weights of payloads stored in Context
+// grouped by view, so that quorum checks don't need to iterate over all
+// payloads on every message. Context payloads must be changed via set*
+// methods only to keep counters in sync.
+type payloadCounters struct {
+	// preparations are weights of PreparationPayloads by payload view.
+	preparations map[View]int
+	// preCommits are weights of PreCommitPayloads by payload view.
+	preCommits map[View]int
+	// commits are weights of CommitPayloads by payload view.
+	commits map[View]int
+	// changeViews are weights of ChangeViewPayloads by requested view.
+	changeViews map[View]int
+	// committed is a weight of validators that have sent Commit or PreCommit.
+	committed int
+	// commitVerified marks CommitPayloads with signatures verified against
+	// the header of their view.
+	commitVerified []bool
+}
+
+// resetCounters clears counters of the payloads that are reset by
+// Context.reset for the given view.
+func (c *Context[H]) resetCounters(view View, n int) {
+	if c.counters.preparations == nil {
+		c.counters.preparations = make(map[View]int)
+		c.counters.preCommits = make(map[View]int)
+		c.counters.commits = make(map[View]int)
+		c.counters.changeViews = make(map[View]int)
+	}
+	clear(c.counters.preparations)
+	clear(c.counters.changeViews)
+	if view == 0 {
+		clear(c.counters.preCommits)
+		clear(c.counters.commits)
+		c.counters.committed = 0
+		c.counters.commitVerified = emptyReusableSlice(c.counters.commitVerified, n)
+	}
+}
+
+func addWeight(m map[View]int, v View, w int) {
+	if m[v] += w; m[v] == 0 {
+		delete(m, v)
+	}
+}
+
+// setPayload replaces payload i in s updating per-view counter cnt.
+func (c *Context[H]) setPayload(s []ConsensusPayload[H], cnt map[View]int, i int, m ConsensusPayload[H]) {
+	if old := s[i]; old != nil {
+		addWeight(cnt, old.ViewNumber(), -c.Weight(i))
+	}
+	if m != nil {
+		addWeight(cnt, m.ViewNumber(), c.Weight(i))
+	}
+	s[i] = m
+}
+
+func (c *Context[H]) setPreparation(i int, m ConsensusPayload[H]) {
+	c.setPayload(c.PreparationPayloads, c.counters.preparations, i, m)
+}
+
+func (c *Context[H]) setPreCommit(i int, m ConsensusPayload[H]) {
+	was := c.isCommitted(i)
+	c.setPayload(c.PreCommitPayloads, c.counters.preCommits, i, m)
+	c.updateCommitted(i, was)
+}
+
+func (c *Context[H]) setCommit(i int, m ConsensusPayload[H]) {
+	was := c.isCommitted(i)
+	c.setPayload(c.CommitPayloads, c.counters.commits, i, m)
+	c.counters.commitVerified[i] = false
+	c.updateCommitted(i, was)
+}
+
+func (c *Context[H]) setChangeView(i int, m ConsensusPayload[H]) {
+	if old := c.ChangeViewPayloads[i]; old != nil {
+		addWeight(c.counters.changeViews, old.GetChangeView().NewViewNumber(), -c.Weight(i))
+	}
+	if m != nil {
+		addWeight(c.counters.changeViews, m.GetChangeView().NewViewNumber(), c.Weight(i))
+	}
+	c.ChangeViewPayloads[i] = m
+}
+
+// isCommitted returns true if validator i has sent Commit or PreCommit.
+func (c *Context[H]) isCommitted(i int) bool {
+	return c.CommitPayloads[i] != nil || c.PreCommitPayloads[i] != nil
+}
+
+func (c *Context[H]) updateCommitted(i int, was bool) {
+	switch now := c.isCommitted(i); {
+	case now && !was:
+		c.counters.committed += c.Weight(i)
+	case !now && was:
+		c.counters.committed -= c.Weight(i)
+	}
+}
+
+// changeViewWeight returns weight of validators that requested to change view
+// to view or higher.
+func (c *Context[H]) changeViewWeight(view View) int {
+	var count int
+	for v, w := range c.counters.changeViews {
+		if v >= view {
+			count += w
+		}
+	}
+	return count
+}
@@ -0,0 +1,93 @@
+package dbft
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	viewPayloadStub struct {
+		payloadStub
+		view    View
+		newView View
+	}
+	changeViewStub struct {
+		newView View
+	}
+)
+
+func (p viewPayloadStub) ViewNumber() View          { return p.view }
+func (p viewPayloadStub) GetChangeView() ChangeView { return changeViewStub{p.newView} }
+
+func (c changeViewStub) NewViewNumber() View      { return c.newView }
+func (c changeViewStub) Reason() ChangeViewReason { return CVTimeout }
+func (c changeViewStub) Timestamp() uint64        { return 0 }
+
+func TestPayloadCounters(t *testing.T) {
+	const (
+		n        = 7
+		maxView  = 3
+		attempts = 10000
+	)
+	var (
+		c = &Context[hash]{
+			Validators: make([]PublicKey, n),
+			weights:    []int{1, 2, 3, 1, 1, 2, 1},
+		}
+		r = rand.New(rand.NewPCG(1, 2))
+	)
+	c.PreparationPayloads = make([]ConsensusPayload[hash], n)
+	c.PreCommitPayloads = make([]ConsensusPayload[hash], n)
+	c.CommitPayloads = make([]ConsensusPayload[hash], n)
+	c.ChangeViewPayloads = make([]ConsensusPayload[hash], n)
+	c.resetCounters(0, n)
+
+	scan := func(s []ConsensusPayload[hash], match func(ConsensusPayload[hash]) bool) (count int) {
+		for i, m := range s {
+			if m != nil && match(m) {
+				count += c.Weight(i)
+			}
+		}
+		return
+	}
+
+	for range attempts {
+		var (
+			i = r.IntN(n)
+			m ConsensusPayload[hash]
+		)
+		if r.IntN(3) != 0 {
+			v := View(r.IntN(maxView))
+			m = viewPayloadStub{view: v, newView: v + View(r.IntN(2))}
+		}
+		switch r.IntN(4) {
+		case 0:
+			c.setPreparation(i, m)
+		case 1:
+			c.setPreCommit(i, m)
+		case 2:
+			c.setCommit(i, m)
+		case 3:
+			c.setChangeView(i, m)
+		}
+
+		for v := range View(maxView + 1) {
+			inView := func(m ConsensusPayload[hash]) bool { return m.ViewNumber() == v }
+			require.Equal(t, scan(c.PreparationPayloads, inView), c.counters.preparations[v])
+			require.Equal(t, scan(c.PreCommitPayloads, inView), c.counters.preCommits[v])
+			require.Equal(t, scan(c.CommitPayloads, inView), c.counters.commits[v])
+			require.Equal(t, scan(c.ChangeViewPayloads, func(m ConsensusPayload[hash]) bool {
+				return m.GetChangeView().NewViewNumber() >= v
+			}), c.changeViewWeight(v))
+		}
+		var committed int
+		for i := range n {
+			if c.CommitPayloads[i] != nil || c.PreCommitPayloads[i] != nil {
+				committed += c.Weight(i)
+			}
+		}
+		require.Equal(t, committed, c.CountCommitted())
+	}
+}
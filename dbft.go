@@ -1,7 +1,9 @@
 package dbft
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"slices"
 	"sync"
 	"time"
@@ -19,8 +21,28 @@ type (
 		Config[H]
 
 		*sync.Mutex
-		cache      cache[H]
-		recovering bool
+		cache   cache[H]
+		fetcher *txFetcher[H]
+		// recovery is RecoveryMessage being processed, if any.
+		recovery ConsensusPayload[H]
+		// verificationErr is a result of asynchronous verification of the
+		// payload being processed.
+		verificationErr error
+		// blockVerifying is true while asynchronous verification of the
+		// proposed block is in progress.
+		blockVerifying bool
+		// preverified are signature verification results of payloads
+		// extracted from RecoveryMessage being processed.
+		preverified map[H]error
+		// seen are hashes of recently processed payloads.
+		seen *seenSet[H]
+		// duplicates are numbers of duplicated payloads per validator.
+		duplicates validatorState[uint64]
+		// Per-validator rate limiters of ChangeView and RecoveryRequest
+		// payloads and RecoveryMessage responses.
+		changeViewLimiter       rateLimiter
+		recoveryRequestLimiter  rateLimiter
+		recoveryResponseLimiter rateLimiter
 	}
 )
 
@@ -45,6 +67,12 @@ func New[H Hash](options ...func(config *Config[H])) (*DBFT[H], error) {
 		Context: Context[H]{
 			Config: cfg,
 		},
+		fetcher: newTxFetcher(cfg),
+		seen:    newSeenSet[H](cfg.MaxSeenPayloads),
+
+		changeViewLimiter:       rateLimiter{limit: cfg.ChangeViewRateLimit},
+		recoveryRequestLimiter:  rateLimiter{limit: cfg.RecoveryRequestRateLimit},
+		recoveryResponseLimiter: rateLimiter{limit: cfg.RecoveryResponseRateLimit},
 	}
 
 	return d, nil
@@ -53,19 +81,27 @@ func New[H Hash](options ...func(config *Config[H])) (*DBFT[H], error) {
 func (d *DBFT[H]) addTransaction(tx Transaction[H]) {
 	d.Transactions[tx.Hash()] = tx
 	if d.hasAllTransactions() {
-		if d.IsPrimary() || d.Context.WatchOnly() {
+		if !d.isAntiMEVExtensionEnabled() {
+			// Header can be constructed now, so check Commits received
+			// so far.
+			d.verifyCommitPayloadsAgainstHeader()
+		}
+		if d.Context.WatchOnly() {
+			// Commits could be received before all transactions.
+			d.checkObservedCommits()
 			return
 		}
-
-		if !d.createAndCheckBlock() {
+		if d.IsPrimary() {
 			return
 		}
 
-		d.verifyPreCommitPayloadsAgainstPreBlock()
+		d.createAndCheckBlock(func() {
+			d.verifyPreCommitPayloadsAgainstPreBlock()
 
-		d.extendTimer(2)
-		d.sendPrepareResponse()
-		d.checkPrepare()
+			d.extendTimer(2)
+			d.sendPrepareResponse()
+			d.checkPrepare()
+		})
 	}
 }
 
@@ -85,12 +121,80 @@ func (d *DBFT[H]) Start(ts uint64) {
 // after new block is processed by ledger (the block can come from dBFT or be
 // received by other means). The height is to be derived from the configured
 // CurrentHeight callback and view will be set to 0.
+//
+// In pipelined mode (see Config.Pipelined) Reset does nothing if consensus
+// is already running at the next height on top of the block persisted.
 func (d *DBFT[H]) Reset(ts uint64) {
+	if d.pendingBlock != nil && d.BlockIndex == d.Config.CurrentHeight()+1 {
+		if d.pendingBlock.Hash() == d.Config.CurrentBlockHash() {
+			d.pendingBlock, d.pendingContext = nil, nil
+			if d.blockProcessed {
+				// The next block is approved already.
+				d.startNextHeight()
+			} else if !d.Context.WatchOnly() && d.RequestSentOrReceived() {
+				// The next block can be committed now.
+				d.checkPrepare()
+			}
+			return
+		}
+		d.Logger.Warn("persisted block differs from the approved one",
+			zap.Uint32("height", d.pendingBlock.Index()),
+			zap.Stringer("approved", d.pendingBlock.Hash()),
+			zap.Stringer("persisted", d.Config.CurrentBlockHash()))
+		d.pendingBlock, d.pendingContext = nil, nil
+	}
 	d.initializeConsensus(0, ts)
 }
 
-func (d *DBFT[H]) initializeConsensus(view byte, ts uint64) {
+// Rollback drops the block approved in pipelined mode (see Config.Pipelined)
+// that failed to be persisted. Consensus returns to the height of this block
+// with all of its payloads, so Commit sent by this node for it is kept and no
+// other block can be signed at this height. The node then works in recovery
+// mode: it requests RecoveryMessage, resends its Commit and retries to approve
+// the block on timeout. If the state of the height is not available (like in
+// observer mode), consensus is restarted from the current ledger state with
+// the given timestamp of the last persisted block.
+func (d *DBFT[H]) Rollback(ts uint64) {
+	if d.pendingBlock == nil {
+		return
+	}
+	d.Logger.Warn("rolling back approved block",
+		zap.Uint32("height", d.pendingBlock.Index()),
+		zap.Stringer("hash", d.pendingBlock.Hash()))
+	saved := d.pendingContext
+	d.pendingBlock, d.pendingContext = nil, nil
+	if saved == nil {
+		d.initializeConsensus(0, ts)
+		return
+	}
+
+	d.fetcher.stop()
+	d.blockVerifying = false
+	d.unsubscribeFromTransactions()
+	d.Context = *saved
+	d.block = nil
+	d.blockProcessed = false
+	d.FastPathApproved = false
+
+	if d.Context.WatchOnly() {
+		return
+	}
+	d.sendRecoveryRequest()
+	if d.CommitSent() {
+		d.sendRecoveryMessage()
+	}
+	d.changeTimer(d.commitTimeout(true))
+}
+
+func (d *DBFT[H]) initializeConsensus(view View, ts uint64) {
+	d.fetcher.stop()
+	d.blockVerifying = false
 	d.reset(view, ts)
+	if view == 0 {
+		d.seen.reset()
+		d.duplicates.retain(d.Validators)
+		d.retainRateLimiters(d.Validators)
+	}
 
 	var role string
 
@@ -139,7 +243,7 @@ func (d *DBFT[H]) initializeConsensus(view byte, ts uint64) {
 	}
 
 	var timeout time.Duration
-	if d.IsPrimary() && !d.recovering {
+	if d.IsPrimary() && d.recovery == nil {
 		// Initializing to view 0 means we have just persisted previous block or are starting consensus first time.
 		// In both cases we should wait full timeout value.
 		// Having non-zero view means we have to start immediately.
@@ -147,13 +251,13 @@ func (d *DBFT[H]) initializeConsensus(view byte, ts uint64) {
 			timeout = d.timePerBlock
 		}
 	} else {
-		timeout = d.timePerBlock << (d.ViewNumber + 1)
+		timeout = d.viewTimeout(d.ViewNumber)
 	}
 	if d.lastBlockIndex+1 == d.BlockIndex {
 		var ts = d.Timer.Now()
 		var diff = ts.Sub(d.lastBlockTime)
 		timeout -= diff
-		timeout -= d.rttEstimates.avg / 2
+		timeout -= d.averageRTT() / 2
 		timeout = max(0, timeout)
 	}
 	d.changeTimer(timeout)
@@ -168,27 +272,35 @@ func (d *DBFT[H]) OnTransaction(tx Transaction[H]) {
 	// 	zap.Bool("request_ok", d.RequestSentOrReceived()),
 	// 	zap.Bool("response_sent", d.ResponseSent()),
 	// 	zap.Bool("block_sent", d.BlockSent()))
-	if !d.IsBackup() || d.NotAcceptingPayloadsDueToViewChanging() ||
+	if !(d.IsBackup() || d.Context.WatchOnly()) || d.NotAcceptingPayloadsDueToViewChanging() ||
 		!d.RequestSentOrReceived() || d.ResponseSent() || d.PreCommitSent() ||
 		d.CommitSent() || d.BlockSent() || len(d.MissingTransactions) == 0 {
 		return
 	}
 
-	i := slices.Index(d.MissingTransactions, tx.Hash())
-	if i < 0 {
+	h := tx.Hash()
+	if _, ok := d.MissingTransactions[h]; !ok {
 		return
 	}
-	d.addTransaction(tx)
-	// `addTransaction` checks for responses and commits. If this was the last transaction
-	// Context could be initialized on a new height, clearing this field.
-	if len(d.MissingTransactions) == 0 {
+	d.fetcher.received(h)
+	if err := d.verifyTransaction(tx); err != nil {
+		d.sendChangeView(txRejectReason(err))
 		return
 	}
-	d.MissingTransactions = slices.Delete(d.MissingTransactions, i, i+1)
+	delete(d.MissingTransactions, h)
+	d.addTransaction(tx)
+}
+
+// OnTxRetry re-requests missing transactions of the proposal if the retry
+// interval has passed (see Config.TxRetryInterval). It must be called
+// periodically (at least every TxRetryInterval) from the same goroutine as
+// other callbacks if retries are enabled.
+func (d *DBFT[H]) OnTxRetry() {
+	d.fetcher.retry()
 }
 
 // OnTimeout advances state machine as if timeout was fired.
-func (d *DBFT[H]) OnTimeout(height uint32, view byte) {
+func (d *DBFT[H]) OnTimeout(height uint32, view View) {
 	d.onTimeout(height, view, false)
 }
 
@@ -201,7 +313,7 @@ func (d *DBFT[H]) OnNewTransaction() {
 	d.onTimeout(d.Timer.Height(), d.Timer.View(), true)
 }
 
-func (d *DBFT[H]) onTimeout(height uint32, view byte, force bool) {
+func (d *DBFT[H]) onTimeout(height uint32, view View, force bool) {
 	if d.Context.WatchOnly() || d.BlockSent() {
 		return
 	}
@@ -224,19 +336,26 @@ func (d *DBFT[H]) onTimeout(height uint32, view byte, force bool) {
 		if d.CommitSent() || d.PreCommitSent() {
 			d.Logger.Debug("send recovery to resend commit")
 			d.sendRecoveryMessage()
-			d.changeTimer(d.timePerBlock << 1)
+			d.changeTimer(d.commitTimeout(true))
+			// Commits may be collected already if the block was rolled
+			// back (see Rollback), retry to approve it then.
+			if d.CommitSent() && !d.checkFastPath() {
+				d.checkCommit()
+			}
+		} else if d.fastPathLocked() {
+			d.Logger.Debug("send recovery to resend preparation")
+			d.sendRecoveryMessage()
+			d.changeTimer(d.viewTimeout(d.ViewNumber))
 		} else {
 			if d.ViewNumber == 0 && d.MaxTimePerBlock != nil && d.IsBackup() {
 				if force {
-					delay := d.timePerBlock << 1
-					d.changeTimer(delay)
+					d.changeTimer(d.viewTimeout(0))
 					d.unsubscribeFromTransactions()
 					return
 				}
 				if !d.txSubscriptionOn && len(d.GetVerified()) == 0 {
 					d.subscribeForTransactions()
-					delay := d.maxTimePerBlock<<1 - d.timePerBlock<<1
-					d.changeTimer(delay)
+					d.changeTimer(d.extendedViewTimeout())
 					return
 				}
 			}
@@ -247,13 +366,19 @@ func (d *DBFT[H]) onTimeout(height uint32, view byte, force bool) {
 
 // OnReceive advances state machine in accordance with msg.
 func (d *DBFT[H]) OnReceive(msg ConsensusPayload[H]) {
-	if int(msg.ValidatorIndex()) >= len(d.Validators) {
-		d.Logger.Error("too big validator index", zap.Uint16("from", msg.ValidatorIndex()))
+	if msg.Payload() == nil {
+		d.Logger.DPanic("invalid message")
 		return
 	}
 
-	if msg.Payload() == nil {
-		d.Logger.DPanic("invalid message")
+	h := msg.Hash()
+	if d.seen.has(h) {
+		// Validator index is not checked yet.
+		if i := int(msg.ValidatorIndex()); i < len(d.Validators) {
+			if n := d.duplicates.get(d.Validators[i]); n != nil {
+				*n++
+			}
+		}
 		return
 	}
 
@@ -266,9 +391,28 @@ func (d *DBFT[H]) OnReceive(msg ConsensusPayload[H]) {
 		zap.Uint("my_view", uint(d.ViewNumber)))
 
 	if msg.Height() < d.BlockIndex {
+		if d.isPendingRecoveryRequest(msg) {
+			d.seen.add(h)
+			if d.VerifyAsync != nil {
+				d.verifyPayloadAsync(msg)
+				return
+			}
+			d.onPendingRecoveryRequest(msg)
+			return
+		}
 		d.Logger.Debug("ignoring old height", zap.Uint32("height", msg.Height()))
 		return
-	} else if msg.Height() > d.BlockIndex ||
+	}
+
+	// Validator index can only be checked against the current validators
+	// list, messages from the next heights are checked once the height is
+	// reached since validators may change.
+	if msg.Height() == d.BlockIndex && int(msg.ValidatorIndex()) >= len(d.Validators) {
+		d.Logger.Error("too big validator index", zap.Uint16("from", msg.ValidatorIndex()))
+		return
+	}
+
+	if msg.Height() > d.BlockIndex ||
 		(msg.ViewNumber() > d.ViewNumber &&
 			msg.Type() != ChangeViewType &&
 			msg.Type() != RecoveryMessageType) {
@@ -290,6 +434,30 @@ func (d *DBFT[H]) OnReceive(msg ConsensusPayload[H]) {
 		return
 	}
 
+	d.seen.add(h)
+	if d.VerifyAsync != nil {
+		d.verifyPayloadAsync(msg)
+		return
+	}
+	d.handle(msg)
+}
+
+// Duplicates returns the number of duplicated payloads received from the
+// validator with the given index and dropped by OnReceive (see
+// Config.MaxSeenPayloads). Counters are tracked by validators' public keys
+// and kept while the validator stays in the validators list.
+func (d *DBFT[H]) Duplicates(i int) uint64 {
+	if i < 0 || i >= len(d.Validators) {
+		return 0
+	}
+	if n := d.duplicates.lookup(d.Validators[i]); n != nil {
+		return *n
+	}
+	return 0
+}
+
+// handle processes msg according to its type.
+func (d *DBFT[H]) handle(msg ConsensusPayload[H]) {
 	switch msg.Type() {
 	case ChangeViewType:
 		d.onChangeView(msg)
@@ -336,77 +504,152 @@ func (d *DBFT[H]) onPrepareRequest(msg ConsensusPayload[H]) {
 		return
 	}
 
-	if err := d.VerifyPrepareRequest(msg); err != nil {
+	if err := d.verifyPayload(msg, d.VerifyPrepareRequest); err != nil {
 		// We should change view if we receive signed PrepareRequest from the expected validator but it is invalid.
 		d.Logger.Warn("invalid PrepareRequest", zap.Uint16("from", msg.ValidatorIndex()), zap.String("error", err.Error()))
 		d.sendChangeView(CVBlockRejectedByPolicy)
 		return
 	}
 
-	d.extendTimer(2)
+	ts := msg.GetPrepareRequest().Timestamp()
+	// Primary uses the minimum allowed timestamp if its clock is behind,
+	// it can't be used for offset estimation then.
+	if ts != d.lastBlockTimestamp+d.TimestampIncrement {
+		d.updateClockOffset(msg.ValidatorIndex(), ts)
+	}
+	if d.isTimestampTooFar(ts) {
+		d.Logger.Warn("PrepareRequest timestamp is too far in the future",
+			zap.Uint16("from", msg.ValidatorIndex()),
+			zap.Uint64("timestamp", ts))
+		d.sendChangeView(CVBlockRejectedByPolicy)
+		return
+	}
 
 	p := msg.GetPrepareRequest()
+	if n := len(p.TransactionHashes()); d.MaxProposalTransactions > 0 && n > d.MaxProposalTransactions {
+		d.Logger.Warn("PrepareRequest exceeds transactions limit",
+			zap.Uint16("from", msg.ValidatorIndex()),
+			zap.Int("count", n))
+		d.sendChangeView(CVBlockRejectedByPolicy)
+		return
+	}
+	if h, ok := findDuplicate(p.TransactionHashes()); ok {
+		d.Logger.Warn("PrepareRequest contains duplicated transaction",
+			zap.Uint16("from", msg.ValidatorIndex()),
+			zap.Stringer("hash", h))
+		d.sendChangeView(CVBlockRejectedByPolicy)
+		return
+	}
+
+	d.extendTimer(2)
 
 	d.Timestamp = p.Timestamp()
 	d.Nonce = p.Nonce()
 	d.TransactionHashes = p.TransactionHashes()
 
 	d.Logger.Info("received PrepareRequest", zap.Uint16("validator", msg.ValidatorIndex()), zap.Int("tx", len(d.TransactionHashes)))
-	d.processMissingTx()
+	if d.recovery == nil {
+		d.prepareReceivedTime = d.Timer.Now()
+	}
+	txErr := d.processMissingTx()
 	d.updateExistingPayloads(msg)
-	d.PreparationPayloads[msg.ValidatorIndex()] = msg
+	d.setPreparation(int(msg.ValidatorIndex()), msg)
 
-	if !d.hasAllTransactions() || !d.createAndCheckBlock() || d.Context.WatchOnly() {
+	if txErr != nil {
+		d.sendChangeView(txRejectReason(txErr))
 		return
 	}
+	if !d.hasAllTransactions() {
+		return
+	}
+
+	d.createAndCheckBlock(func() {
+		if d.Context.WatchOnly() {
+			// Commits could be received before PrepareRequest.
+			d.checkObservedCommits()
+			return
+		}
 
-	d.sendPrepareResponse()
-	d.checkPrepare()
+		d.sendPrepareResponse()
+		d.checkPrepare()
+	})
 }
 
-func (d *DBFT[H]) processMissingTx() {
+// processMissingTx collects proposed transactions available locally and
+// requests the missing ones. It returns an error if some transaction fails
+// verification.
+func (d *DBFT[H]) processMissingTx() error {
 	for _, h := range d.TransactionHashes {
 		if _, ok := d.Transactions[h]; ok {
 			continue
 		}
-		if tx := d.GetTx(h); tx == nil {
-			d.MissingTransactions = append(d.MissingTransactions, h)
-		} else {
-			d.Transactions[h] = tx
+		tx := d.GetTx(h)
+		if tx == nil {
+			d.MissingTransactions[h] = struct{}{}
+			continue
 		}
+		if err := d.verifyTransaction(tx); err != nil {
+			return err
+		}
+		d.Transactions[h] = tx
 	}
 
 	if len(d.MissingTransactions) != 0 {
 		d.Logger.Info("missing tx",
 			zap.Int("count", len(d.MissingTransactions)))
-		d.RequestTx(d.MissingTransactions...)
+		d.fetcher.fetch(int(d.PrimaryIndex), slices.Collect(maps.Keys(d.MissingTransactions)))
 	}
+	return nil
 }
 
-// createAndCheckBlock is a prepareRequest-level helper that creates and checks
-// the new proposed block, if it's fine it returns true, if something is wrong
-// with it, it sends a changeView request and returns false. It's only valid to
-// call it when all transactions for this block are already collected.
-func (d *DBFT[H]) createAndCheckBlock() bool {
-	var blockOK bool
-	if d.isAntiMEVExtensionEnabled() {
-		b := d.CreatePreBlock()
-		blockOK = d.VerifyPreBlock(b)
-		if !blockOK {
-			d.Logger.Warn("proposed preBlock fails verification")
-		}
-	} else {
-		b := d.CreateBlock()
-		blockOK = d.VerifyBlock(b)
-		if !blockOK {
-			d.Logger.Warn("proposed block fails verification")
+// verifyTransaction checks proposed transaction with VerifyTransaction
+// callback and reports rejected ones via OnTxRejected. WatchOnly nodes don't
+// verify transactions since they don't vote anyway.
+func (d *DBFT[H]) verifyTransaction(tx Transaction[H]) error {
+	if d.VerifyTransaction == nil || d.Context.WatchOnly() {
+		return nil
+	}
+	err := d.VerifyTransaction(tx)
+	if err != nil {
+		d.Logger.Warn("proposed transaction fails verification",
+			zap.Stringer("hash", tx.Hash()),
+			zap.Error(err))
+		if d.OnTxRejected != nil {
+			d.OnTxRejected(tx.Hash(), err)
 		}
 	}
-	if !blockOK {
-		d.sendChangeView(CVTxInvalid)
-		return false
+	return err
+}
+
+// txRejectReason returns ChangeView reason for the transaction verification
+// error.
+func txRejectReason(err error) ChangeViewReason {
+	if errors.Is(err, ErrTxRejectedByPolicy) {
+		return CVTxRejectedByPolicy
 	}
-	return true
+	return CVTxInvalid
+}
+
+// createAndCheckBlock is a prepareRequest-level helper that creates and checks
+// the new proposed block, if it's fine it calls next (asynchronously in
+// asynchronous verification mode), if something is wrong with it (including
+// proposal limits violation), it sends a changeView request. It's only valid
+// to call it when all transactions for this block are already collected.
+func (d *DBFT[H]) createAndCheckBlock(next func()) {
+	if err := d.checkProposalLimits(); err != nil {
+		d.Logger.Warn("proposal rejected by policy", zap.Error(err))
+		d.sendChangeView(CVBlockRejectedByPolicy)
+		return
+	}
+
+	d.verifyBlock(func(err error) {
+		if err != nil {
+			d.Logger.Warn(err.Error())
+			d.sendChangeView(CVTxInvalid)
+			return
+		}
+		next()
+	})
 }
 
 // updateExistingPayloads is called _only_ from onPrepareRequest, it validates
@@ -416,7 +659,7 @@ func (d *DBFT[H]) updateExistingPayloads(msg ConsensusPayload[H]) {
 		if m != nil && m.Type() == PrepareResponseType {
 			resp := m.GetPrepareResponse()
 			if resp != nil && resp.PreparationHash() != msg.Hash() {
-				d.PreparationPayloads[i] = nil
+				d.setPreparation(i, nil)
 			}
 		}
 	}
@@ -440,7 +683,7 @@ func (d *DBFT[H]) verifyPreCommitPayloadsAgainstPreBlock() {
 			if preBlock := d.CreatePreBlock(); preBlock != nil {
 				pub := d.Validators[m.ValidatorIndex()]
 				if err := preBlock.Verify(pub, m.GetPreCommit().Data()); err != nil {
-					d.PreCommitPayloads[i] = nil
+					d.setPreCommit(i, nil)
 					d.Logger.Warn("PreCommit verification failed",
 						zap.Uint16("from", m.ValidatorIndex()),
 						zap.Error(err))
@@ -451,15 +694,18 @@ func (d *DBFT[H]) verifyPreCommitPayloadsAgainstPreBlock() {
 }
 
 // verifyCommitPayloadsAgainstHeader performs verification of commit payloads
-// against generated header.
+// against generated header. Signatures that are already verified are not
+// checked again.
 func (d *DBFT[H]) verifyCommitPayloadsAgainstHeader() {
 	for i, m := range d.CommitPayloads {
-		if m != nil && m.ViewNumber() == d.ViewNumber {
+		if m != nil && m.ViewNumber() == d.ViewNumber && !d.counters.commitVerified[i] {
 			if header := d.MakeHeader(); header != nil {
 				pub := d.Validators[m.ValidatorIndex()]
 				if header.Verify(pub, m.GetCommit().Signature()) != nil {
-					d.CommitPayloads[i] = nil
+					d.setCommit(i, nil)
 					d.Logger.Warn("can't validate commit signature")
+				} else {
+					d.counters.commitVerified[i] = true
 				}
 			}
 		}
@@ -486,12 +732,12 @@ func (d *DBFT[H]) onPrepareResponse(msg ConsensusPayload[H]) {
 		return
 	}
 
-	if err := d.VerifyPrepareResponse(msg); err != nil {
+	if err := d.verifyPayload(msg, d.VerifyPrepareResponse); err != nil {
 		d.Logger.Warn("invalid PrepareResponse", zap.Uint16("from", msg.ValidatorIndex()), zap.String("error", err.Error()))
 		return
 	}
 	d.Logger.Info("received PrepareResponse", zap.Uint16("validator", msg.ValidatorIndex()))
-	d.PreparationPayloads[msg.ValidatorIndex()] = msg
+	d.setPreparation(int(msg.ValidatorIndex()), msg)
 
 	if m = d.PreparationPayloads[d.GetPrimaryIndex(d.ViewNumber)]; m != nil {
 		req := m.GetPrepareRequest()
@@ -502,7 +748,7 @@ func (d *DBFT[H]) onPrepareResponse(msg ConsensusPayload[H]) {
 
 		prepHash := msg.GetPrepareResponse().PreparationHash()
 		if h := m.Hash(); prepHash != h {
-			d.PreparationPayloads[msg.ValidatorIndex()] = nil
+			d.setPreparation(int(msg.ValidatorIndex()), nil)
 			d.Logger.Debug("hash mismatch",
 				zap.Stringer("primary", h),
 				zap.Stringer("received", prepHash))
@@ -511,18 +757,27 @@ func (d *DBFT[H]) onPrepareResponse(msg ConsensusPayload[H]) {
 		}
 	}
 
-	if d.IsPrimary() && !d.prepareSentTime.IsZero() && !d.recovering {
-		d.rttEstimates.addTime(time.Since(d.prepareSentTime))
+	d.fetcher.addHolder(int(msg.ValidatorIndex()))
+
+	if d.IsPrimary() && !d.prepareSentTime.IsZero() && d.recovery == nil {
+		d.RTTEstimator.Add(PrepareRTT, d.Validators[msg.ValidatorIndex()], time.Since(d.prepareSentTime))
 	}
 
 	d.extendTimer(2)
 
 	if !d.Context.WatchOnly() && !d.CommitSent() && (!d.isAntiMEVExtensionEnabled() || !d.PreCommitSent()) && d.RequestSentOrReceived() {
 		d.checkPrepare()
+	} else if d.CommitSent() {
+		d.checkFastPath()
 	}
 }
 
 func (d *DBFT[H]) onChangeView(msg ConsensusPayload[H]) {
+	if err := d.verifyPayload(msg, d.VerifyChangeView); err != nil {
+		d.Logger.Warn("invalid ChangeView", zap.Uint16("from", msg.ValidatorIndex()), zap.String("error", err.Error()))
+		return
+	}
+
 	p := msg.GetChangeView()
 
 	if p.NewViewNumber() <= d.ViewNumber {
@@ -532,9 +787,14 @@ func (d *DBFT[H]) onChangeView(msg ConsensusPayload[H]) {
 		return
 	}
 
+	if p.NewViewNumber() > d.MaxViewNumber {
+		d.Logger.Debug("ignoring ChangeView: maximum view number exceeded", zap.Uint("new_view", uint(p.NewViewNumber())))
+		return
+	}
+
 	if d.CommitSent() || d.PreCommitSent() {
 		d.Logger.Debug("ignoring ChangeView: preCommit or commit sent")
-		d.sendRecoveryMessage()
+		d.sendRecoveryResponse(msg)
 		return
 	}
 
@@ -543,13 +803,18 @@ func (d *DBFT[H]) onChangeView(msg ConsensusPayload[H]) {
 		return
 	}
 
+	if d.rateLimited(msg, d.Validators) {
+		return
+	}
+
 	d.Logger.Info("received ChangeView",
 		zap.Uint("validator", uint(msg.ValidatorIndex())),
 		zap.Stringer("reason", p.Reason()),
 		zap.Uint("new view", uint(p.NewViewNumber())),
 	)
 
-	d.ChangeViewPayloads[msg.ValidatorIndex()] = msg
+	d.setChangeView(int(msg.ValidatorIndex()), msg)
+	d.updateClockOffset(msg.ValidatorIndex(), p.Timestamp())
 	d.checkChangeView(p.NewViewNumber())
 }
 
@@ -567,10 +832,10 @@ func (d *DBFT[H]) onPreCommit(msg ConsensusPayload[H]) {
 		}
 		return
 	}
-	d.PreCommitPayloads[msg.ValidatorIndex()] = msg
+	d.setPreCommit(int(msg.ValidatorIndex()), msg)
 	if d.ViewNumber == msg.ViewNumber() {
-		if err := d.VerifyPreCommit(msg); err != nil {
-			d.PreCommitPayloads[msg.ValidatorIndex()] = nil
+		if err := d.verifyPayload(msg, d.VerifyPreCommit); err != nil {
+			d.setPreCommit(int(msg.ValidatorIndex()), nil)
 			d.Logger.Warn("invalid PreCommit", zap.Uint16("from", msg.ValidatorIndex()), zap.String("error", err.Error()))
 			return
 		}
@@ -584,10 +849,12 @@ func (d *DBFT[H]) onPreCommit(msg ConsensusPayload[H]) {
 		preBlock := d.CreatePreBlock()
 		if preBlock != nil {
 			pub := d.Validators[msg.ValidatorIndex()]
-			if err := preBlock.Verify(pub, msg.GetPreCommit().Data()); err == nil {
+			if err := d.verifySignature(msg, func() error {
+				return preBlock.Verify(pub, msg.GetPreCommit().Data())
+			}); err == nil {
 				d.checkPreCommit()
 			} else {
-				d.PreCommitPayloads[msg.ValidatorIndex()] = nil
+				d.setPreCommit(int(msg.ValidatorIndex()), nil)
 				d.Logger.Warn("invalid preCommit data",
 					zap.Uint("validator", uint(msg.ValidatorIndex())),
 					zap.Error(err),
@@ -617,23 +884,29 @@ func (d *DBFT[H]) onCommit(msg ConsensusPayload[H]) {
 		}
 		return
 	}
-	d.CommitPayloads[msg.ValidatorIndex()] = msg
+	d.setCommit(int(msg.ValidatorIndex()), msg)
 	if d.ViewNumber == msg.ViewNumber() {
-		if err := d.VerifyCommit(msg); err != nil {
-			d.CommitPayloads[msg.ValidatorIndex()] = nil
+		if err := d.verifyPayload(msg, d.VerifyCommit); err != nil {
+			d.setCommit(int(msg.ValidatorIndex()), nil)
 			d.Logger.Warn("invalid Commit", zap.Uint16("from", msg.ValidatorIndex()), zap.String("error", err.Error()))
 			return
 		}
 
 		d.Logger.Info("received Commit", zap.Uint("validator", uint(msg.ValidatorIndex())))
+		if d.IsBackup() && !d.prepareReceivedTime.IsZero() && d.recovery == nil {
+			d.RTTEstimator.Add(CommitRTT, d.Validators[msg.ValidatorIndex()], time.Since(d.prepareReceivedTime))
+		}
 		d.extendTimer(4)
 		header := d.MakeHeader()
 		if header != nil {
 			pub := d.Validators[msg.ValidatorIndex()]
-			if err := header.Verify(pub, msg.GetCommit().Signature()); err == nil {
+			if err := d.verifySignature(msg, func() error {
+				return header.Verify(pub, msg.GetCommit().Signature())
+			}); err == nil {
+				d.counters.commitVerified[msg.ValidatorIndex()] = true
 				d.checkCommit()
 			} else {
-				d.CommitPayloads[msg.ValidatorIndex()] = nil
+				d.setCommit(int(msg.ValidatorIndex()), nil)
 				d.Logger.Warn("invalid commit signature",
 					zap.Uint("validator", uint(msg.ValidatorIndex())),
 					zap.Error(err),
@@ -651,6 +924,14 @@ func (d *DBFT[H]) onCommit(msg ConsensusPayload[H]) {
 }
 
 func (d *DBFT[H]) onRecoveryRequest(msg ConsensusPayload[H]) {
+	if msg.Type() == RecoveryRequestType {
+		if err := d.verifyPayload(msg, d.VerifyRecoveryRequest); err != nil {
+			d.Logger.Warn("invalid RecoveryRequest", zap.Uint16("from", msg.ValidatorIndex()), zap.String("error", err.Error()))
+			return
+		}
+		d.updateClockOffset(msg.ValidatorIndex(), msg.GetRecoveryRequest().Timestamp())
+	}
+
 	// Only validators are allowed to send consensus messages.
 	if d.Context.WatchOnly() {
 		return
@@ -660,14 +941,77 @@ func (d *DBFT[H]) onRecoveryRequest(msg ConsensusPayload[H]) {
 		// Ignore the message if our index is not in F+1 range of the
 		// next (%N) ones from the sender. This limits recovery
 		// messages to be broadcasted through the network and F+1
-		// guarantees that at least one node responds.
-
-		if (d.MyIndex-int(msg.ValidatorIndex())+d.N()-1)%d.N() > d.F() {
+		// guarantees that at least one node responds. For weighted
+		// validators the range is extended until its weight exceeds F.
+		if !d.isRecoveryResponder(int(msg.ValidatorIndex())) {
 			return
 		}
 	}
 
-	d.sendRecoveryMessage()
+	d.sendRecoveryResponse(msg)
+}
+
+// isPendingRecoveryRequest checks whether msg is RecoveryRequest for the
+// height of the block approved in pipelined mode, but not yet persisted.
+func (d *DBFT[H]) isPendingRecoveryRequest(msg ConsensusPayload[H]) bool {
+	return d.pendingContext != nil && msg.Height() == d.pendingContext.BlockIndex && msg.Type() == RecoveryRequestType
+}
+
+// onPendingRecoveryRequest answers RecoveryRequest for the height of the block
+// approved in pipelined mode, but not yet persisted. Nodes that haven't
+// approved this block can't get it from the ledger of other nodes yet. It's
+// verified and rate limited the same way as RecoveryRequest for the current
+// height.
+func (d *DBFT[H]) onPendingRecoveryRequest(msg ConsensusPayload[H]) {
+	var (
+		p         = d.pendingContext
+		validator = int(msg.ValidatorIndex())
+	)
+	if p.WatchOnly() || validator >= len(p.Validators) {
+		return
+	}
+	if err := d.verifyPayload(msg, d.VerifyRecoveryRequest); err != nil {
+		d.Logger.Warn("invalid RecoveryRequest", zap.Uint16("from", msg.ValidatorIndex()), zap.String("error", err.Error()))
+		return
+	}
+	if d.rateLimited(msg, p.Validators) {
+		return
+	}
+	if !d.recoveryResponseLimiter.allow(p.Validators[validator], d.Timer.Now()) {
+		d.reportRateLimited(validator, RecoveryMessageType)
+		return
+	}
+	d.Logger.Debug("sending RecoveryMessage for the pending block",
+		zap.Uint32("height", p.BlockIndex),
+		zap.Int("to", validator))
+
+	// Payloads known by the requester can only be skipped if nobody else
+	// receives the reply.
+	req := msg
+	if d.SendTo == nil {
+		req = nil
+	}
+	resp := p.makeRecoveryMessage(req)
+	resp.SetValidatorIndex(uint16(p.MyIndex))
+	if d.SendTo != nil {
+		d.SendTo(validator, resp)
+	} else {
+		d.Broadcast(resp)
+	}
+}
+
+// isRecoveryResponder returns true iff the node is in the range of nodes
+// following the sender that have to respond to its RecoveryRequest.
+func (d *DBFT[H]) isRecoveryResponder(sender int) bool {
+	var weight int
+	for i := 1; i < d.N() && weight <= d.F(); i++ {
+		idx := (sender + i) % d.N()
+		if idx == d.MyIndex {
+			return true
+		}
+		weight += d.Weight(idx)
+	}
+	return false
 }
 
 func (d *DBFT[H]) onRecoveryMessage(msg ConsensusPayload[H]) {
@@ -681,8 +1025,13 @@ func (d *DBFT[H]) onRecoveryMessage(msg ConsensusPayload[H]) {
 		total                         = len(d.Validators)
 	)
 
-	// isRecovering is always set to false again after OnRecoveryMessageReceived
-	d.recovering = true
+	if err := d.verifyPayload(msg, d.VerifyRecoveryMessage); err != nil {
+		d.Logger.Warn("invalid RecoveryMessage", zap.Uint16("from", msg.ValidatorIndex()), zap.String("error", err.Error()))
+		return
+	}
+
+	// recovery is always set to nil again after RecoveryMessage processing.
+	d.recovery = msg
 
 	defer func() {
 		d.Logger.Sugar().Debugf("recovering finished cv=%d/%d preq=%d/%d presp=%d/%d pco=%d/%d co=%d/%d",
@@ -691,7 +1040,8 @@ func (d *DBFT[H]) onRecoveryMessage(msg ConsensusPayload[H]) {
 			validPrepResp, total,
 			validPreCommits, total,
 			validCommits, total)
-		d.recovering = false
+		d.recovery = nil
+		clear(d.preverified)
 	}()
 
 	if msg.ViewNumber() > d.ViewNumber {
@@ -724,18 +1074,32 @@ func (d *DBFT[H]) onRecoveryMessage(msg ConsensusPayload[H]) {
 
 	// Ensure we know about all (pre) commits from lower view numbers.
 	if msg.ViewNumber() <= d.ViewNumber {
-		for _, m := range recovery.GetPreCommits(msg, d.Validators) {
+		preCommits := recovery.GetPreCommits(msg, d.Validators)
+		d.preverifyPreCommits(preCommits)
+		for _, m := range preCommits {
 			validPreCommits++
 			d.OnReceive(m)
 		}
 
-		for _, m := range recovery.GetCommits(msg, d.Validators) {
+		// Header may be available only after PreCommits are processed.
+		commits := recovery.GetCommits(msg, d.Validators)
+		d.preverifyCommits(commits)
+		for _, m := range commits {
 			validCommits++
 			d.OnReceive(m)
 		}
 	}
 }
 
+// RecoverySource returns RecoveryMessage payload the payload being verified
+// (or processed) was extracted from, it's nil for payloads received directly.
+// It can be used by Config.Verify* callbacks to take the origin of the
+// payload into account, see VerificationTask.Recovery for the asynchronous
+// verification mode.
+func (d *DBFT[H]) RecoverySource() ConsensusPayload[H] {
+	return d.recovery
+}
+
 func (d *DBFT[H]) changeTimer(delay time.Duration) {
 	d.Logger.Debug("reset timer",
 		zap.Uint32("h", d.BlockIndex),
@@ -746,6 +1110,8 @@ func (d *DBFT[H]) changeTimer(delay time.Duration) {
 
 func (d *DBFT[H]) extendTimer(count int) {
 	if !d.CommitSent() && (!d.isAntiMEVExtensionEnabled() || !d.PreCommitSent()) && !d.ViewChanging() {
-		d.Timer.Extend(time.Duration(count) * d.timePerBlock / time.Duration(d.M()))
+		// Validator count-based M is used irrespective of weights.
+		m := d.N() - (d.N()-1)/3
+		d.Timer.Extend(time.Duration(count) * d.timePerBlock / time.Duration(m))
 	}
 }
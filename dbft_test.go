@@ -3,7 +3,10 @@ package dbft_test
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
 	"testing"
 	"time"
 
@@ -551,7 +554,7 @@ func TestDBFT_Invalid(t *testing.T) {
 		require.Error(t, err)
 	})
 
-	opts = append(opts, dbft.WithNewChangeView[crypto.Uint256](func(byte, dbft.ChangeViewReason, uint64) dbft.ChangeView {
+	opts = append(opts, dbft.WithNewChangeView[crypto.Uint256](func(dbft.View, dbft.ChangeViewReason, uint64) dbft.ChangeView {
 		return nil
 	}))
 	t.Run("without NewCommit", func(t *testing.T) {
@@ -693,12 +696,12 @@ func TestDBFT_FourGoodNodesDeadlock(t *testing.T) {
 	// Step 8. The primary (at view 0) replica 1 collects M ChangeView messages
 	// (from itself and replicas 1, 3) and changes its view to 1.
 	s1.OnReceive(cv0V0)
-	require.Equal(t, uint8(1), s1.ViewNumber)
+	require.Equal(t, dbft.View(1), s1.ViewNumber)
 
 	// Step 9. The backup (at view 0) replica 0 collects M ChangeView messages
 	// (from itself and replicas 0, 3) and changes its view to 1.
 	s0.OnReceive(cv1V0)
-	require.Equal(t, uint8(1), s0.ViewNumber)
+	require.Equal(t, dbft.View(1), s0.ViewNumber)
 
 	// Step 10. The primary (at view 1) replica 0 sends the PrepareRequest message.
 	s0.OnTimeout(r0.currHeight+1, 1)
@@ -719,7 +722,7 @@ func TestDBFT_FourGoodNodesDeadlock(t *testing.T) {
 	// (from itself and replicas 0, 1) and changes its view to 1.
 	s3.OnReceive(cv0V0)
 	s3.OnReceive(cv1V0)
-	require.Equal(t, uint8(1), s3.ViewNumber)
+	require.Equal(t, dbft.View(1), s3.ViewNumber)
 
 	// Intermediate step A. It is added to make step 14 possible. The backup (at
 	// view 1) replica 3 doesn't receive anything for a long time and sends
@@ -1023,7 +1026,2396 @@ func TestDBFT_CachedMessages(t *testing.T) {
 	}
 }
 
-func (s testState) getChangeView(from uint16, view byte) Payload {
+func TestDBFT_NextValidators(t *testing.T) {
+	s := newTestState(0, 1)
+	_, nextPubs := getTestValidators(3)
+	nextPubs = append(nextPubs, s.pubs[0])
+
+	tx := testTx(1)
+	s.pool.Add(tx)
+	s.currHeight = 1
+	opts := append(s.getOptions(),
+		dbft.WithGetVerified[crypto.Uint256](func() []dbft.Transaction[crypto.Uint256] {
+			return []dbft.Transaction[crypto.Uint256]{tx}
+		}),
+		dbft.WithGetValidators[crypto.Uint256](func(txs ...dbft.Transaction[crypto.Uint256]) []dbft.PublicKey {
+			if len(txs) > 0 {
+				return nextPubs
+			}
+			return s.pubs
+		}),
+		dbft.WithGetKeyPair[crypto.Uint256](func(pubs []dbft.PublicKey) (int, dbft.PrivateKey, dbft.PublicKey) {
+			for i := range pubs {
+				if pubs[i] == s.pubs[0] {
+					return i, s.privs[0], s.pubs[0]
+				}
+			}
+			return -1, nil, nil
+		}),
+	)
+	service, err := dbft.New[crypto.Uint256](opts...)
+	require.NoError(t, err)
+
+	service.Start(0)
+	require.Equal(t, dbft.PrepareRequestType, s.tryRecv().Type())
+	require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+	require.NotNil(t, s.nextBlock())
+	require.Equal(t, nextPubs, service.NextValidators)
+
+	// Messages from the next height are cached even if validator index
+	// doesn't fit the current committee.
+	s.currHeight++
+	service.OnReceive(s.getChangeView(2, 1))
+
+	service.Reset(0)
+	require.Equal(t, nextPubs, service.Validators)
+	require.Nil(t, service.NextValidators)
+	require.Equal(t, 3, service.MyIndex)
+	require.NotNil(t, service.ChangeViewPayloads[2])
+
+	// Out of range index is still rejected for the current height.
+	service.OnReceive(s.getChangeView(4, 1))
+	require.Nil(t, s.tryRecv())
+}
+
+// nextConsensusBlock commits to the given next validators hash.
+type nextConsensusBlock struct {
+	dbft.Block[crypto.Uint256]
+	next crypto.Uint256
+}
+
+func (b nextConsensusBlock) NextConsensus() crypto.Uint256 { return b.next }
+
+func TestDBFT_NextConsensus(t *testing.T) {
+	validatorsHash := func(pubs []dbft.PublicKey) crypto.Uint256 {
+		var b []byte
+		for _, p := range pubs {
+			b = append(b, p.(*crypto.ECDSAPub).X.Bytes()...)
+		}
+		return crypto.Hash256(b)
+	}
+
+	tx := testTx(1)
+	s := newTestState(2, 4)
+	s.currHeight = 1
+	agreed := validatorsHash(s.pubs)
+	disagreed := slices.Clone(s.pubs)
+	slices.Reverse(disagreed)
+
+	// newNode creates a validator that computes the next validators list
+	// either the same way the header commits to or differently.
+	newNode := func(s *testState, agree bool) *dbft.DBFT[crypto.Uint256] {
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithValidatorsHash[crypto.Uint256](validatorsHash),
+			dbft.WithGetVerified[crypto.Uint256](func() []dbft.Transaction[crypto.Uint256] {
+				return []dbft.Transaction[crypto.Uint256]{tx}
+			}),
+			dbft.WithGetValidators[crypto.Uint256](func(txs ...dbft.Transaction[crypto.Uint256]) []dbft.PublicKey {
+				if len(txs) > 0 && !agree {
+					return disagreed
+				}
+				return s.pubs
+			}),
+			dbft.WithNewBlockFromContext[crypto.Uint256](func(ctx *dbft.Context[crypto.Uint256]) dbft.Block[crypto.Uint256] {
+				b := newBlockFromContext(ctx)
+				if b == nil {
+					return nil
+				}
+				return nextConsensusBlock{b, agreed}
+			}))...)
+		require.NoError(t, err)
+		return service
+	}
+
+	t.Run("header is nil until all transactions are collected", func(t *testing.T) {
+		s := s.copyWithIndex(1)
+		service := newNode(s, true)
+		service.Start(0)
+
+		service.OnReceive(s.getPrepareRequest(2, tx.Hash()))
+		require.Nil(t, service.MakeHeader())
+		require.Nil(t, service.NextValidators)
+		require.Nil(t, s.tryRecv())
+
+		service.OnTransaction(tx)
+		require.NotNil(t, service.MakeHeader())
+		require.Equal(t, s.pubs, service.NextValidators)
+		require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+	})
+
+	t.Run("backups agree", func(t *testing.T) {
+		s := s.copyWithIndex(1)
+		s.pool.Add(tx)
+		service := newNode(s, true)
+		service.Start(0)
+
+		service.OnReceive(s.getPrepareRequest(2, tx.Hash()))
+		require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+	})
+
+	t.Run("backup disagrees", func(t *testing.T) {
+		s := s.copyWithIndex(1)
+		s.pool.Add(tx)
+		service := newNode(s, false)
+		service.Start(0)
+
+		service.OnReceive(s.getPrepareRequest(2, tx.Hash()))
+		require.Equal(t, dbft.ChangeViewType, s.tryRecv().Type())
+		require.False(t, service.ResponseSent())
+	})
+
+	t.Run("primary disagrees", func(t *testing.T) {
+		s := s.copyWithIndex(2)
+		service := newNode(s, false)
+		service.Start(0)
+
+		req := s.tryRecv()
+		require.Equal(t, dbft.PrepareRequestType, req.Type())
+		service.OnReceive(s.getPrepareResponse(0, req.Hash(), 0))
+		service.OnReceive(s.getPrepareResponse(1, req.Hash(), 0))
+		require.Nil(t, s.tryRecv())
+		require.False(t, service.CommitSent())
+	})
+}
+
+func TestDBFT_PrimarySelector(t *testing.T) {
+	// Primary is (height + view) mod N instead of the default rotation.
+	selector := func(height uint32, view dbft.View, validators []dbft.PublicKey) uint {
+		return uint((int(height) + int(view)) % len(validators))
+	}
+	s := newTestState(3, 4)
+	s.currHeight = 2
+
+	t.Run("primary sends PrepareRequest on start", func(t *testing.T) {
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(), dbft.WithPrimarySelector[crypto.Uint256](selector))...)
+		require.NoError(t, err)
+
+		service.Start(0)
+		require.True(t, service.IsPrimary())
+		p := s.tryRecv()
+		require.NotNil(t, p)
+		require.Equal(t, dbft.PrepareRequestType, p.Type())
+		require.EqualValues(t, 3, p.ValidatorIndex())
+	})
+
+	t.Run("backup accepts PrepareRequest from the selected primary only", func(t *testing.T) {
+		s := s.copyWithIndex(0)
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(), dbft.WithPrimarySelector[crypto.Uint256](selector))...)
+		require.NoError(t, err)
+
+		service.Start(0)
+		require.EqualValues(t, 3, service.PrimaryIndex)
+		require.Nil(t, s.tryRecv())
+
+		service.OnReceive(s.getPrepareRequest(1))
+		require.Nil(t, s.tryRecv())
+		require.False(t, service.RequestSentOrReceived())
+
+		service.OnReceive(s.getPrepareRequest(3))
+		require.True(t, service.RequestSentOrReceived())
+		resp := s.tryRecv()
+		require.NotNil(t, resp)
+		require.Equal(t, dbft.PrepareResponseType, resp.Type())
+	})
+
+	t.Run("change view", func(t *testing.T) {
+		s := s.copyWithIndex(0)
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(), dbft.WithPrimarySelector[crypto.Uint256](selector))...)
+		require.NoError(t, err)
+
+		service.Start(0)
+		for i := range 3 {
+			service.OnReceive(s.getChangeView(uint16(i+1), 1))
+		}
+		require.EqualValues(t, 1, service.ViewNumber)
+		// Default selector would give 2 here.
+		require.True(t, service.IsPrimary())
+	})
+
+	t.Run("out of range index", func(t *testing.T) {
+		s := s.copyWithIndex(0)
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithPrimarySelector[crypto.Uint256](func(uint32, dbft.View, []dbft.PublicKey) uint { return 100 }))...)
+		require.NoError(t, err)
+
+		// Default selector is used: (3 - 0) mod 4.
+		service.Start(0)
+		require.EqualValues(t, 3, service.PrimaryIndex)
+		require.EqualValues(t, 2, service.GetPrimaryIndex(1))
+
+		service.OnReceive(s.getPrepareRequest(3))
+		require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+	})
+}
+
+func TestDBFT_Reputation(t *testing.T) {
+	s := newTestState(1, 4)
+	s.currHeight = 1
+	rep := dbft.NewReputation()
+	service, err := dbft.New[crypto.Uint256](append(s.getOptions(), dbft.WithReputation[crypto.Uint256](rep))...)
+	require.NoError(t, err)
+
+	service.Start(0)
+	require.Nil(t, s.tryRecv())
+
+	// Primary 2 is offline, change view to 1 where we're primary.
+	for _, i := range []uint16{0, 3, 2} {
+		service.OnReceive(s.getChangeView(i, 1))
+	}
+	require.EqualValues(t, 1, service.ViewNumber)
+	require.True(t, service.IsPrimary())
+	require.Nil(t, s.tryRecv())
+
+	service.OnTimeout(s.currHeight+1, 1)
+	req := s.tryRecv()
+	require.Equal(t, dbft.PrepareRequestType, req.Type())
+	for _, i := range []uint16{0, 3} {
+		service.OnReceive(s.getPrepareResponse(i, req.Hash(), 1))
+	}
+	require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+	for _, i := range []int{0, 3} {
+		require.NoError(t, service.Header().Sign(s.privs[i]))
+		service.OnReceive(s.getCommit(uint16(i), service.Header().Signature(), 1))
+	}
+	require.NotNil(t, s.nextBlock())
+
+	require.Equal(t, dbft.ValidatorStats{Missed: 1, LastMissed: 2}, rep.Stats(s.pubs[2]))
+	require.Equal(t, -1, rep.Score(s.pubs[2]))
+	require.Equal(t, dbft.ValidatorStats{Proposed: 1}, rep.Stats(s.pubs[1]))
+	require.Equal(t, dbft.ValidatorStats{}, rep.Stats(s.pubs[0]))
+	require.Equal(t, 0, rep.Score(s.pubs[0]))
+
+	t.Run("rebuild from ledger", func(t *testing.T) {
+		restored := dbft.NewReputation()
+		restored.AddBlock(2, 1, service.Validators, dbft.DefaultPrimarySelector)
+		for _, pub := range service.Validators {
+			require.Equal(t, rep.Stats(pub), restored.Stats(pub))
+		}
+	})
+
+	t.Run("selector", func(t *testing.T) {
+		selector := rep.PrimarySelector(4)
+		pubs := service.Validators
+		// Validator 2 is demoted up to height 6: 0, 1, 3 rotate.
+		require.EqualValues(t, 0, selector(3, 0, pubs))
+		require.EqualValues(t, 1, selector(4, 0, pubs))
+		require.EqualValues(t, 0, selector(4, 1, pubs))
+		require.EqualValues(t, 3, selector(4, 2, pubs))
+		require.EqualValues(t, 1, selector(4, 3, pubs))
+		require.EqualValues(t, 2, selector(7, 1, pubs))
+
+		// Consecutive miss doubles the period.
+		rep.RecordMiss(pubs[2], 7)
+		require.EqualValues(t, 3, selector(15, 1, pubs))
+		require.EqualValues(t, 2, selector(18, 0, pubs))
+
+		rep.RecordProposal(pubs[2])
+		require.EqualValues(t, 2, selector(10, 0, pubs))
+	})
+
+	t.Run("validators change", func(t *testing.T) {
+		rep := dbft.NewReputation()
+		rep.AddBlock(2, 1, s.pubs, dbft.DefaultPrimarySelector)
+		require.EqualValues(t, 1, rep.Stats(s.pubs[2]).Missed)
+
+		// Validator 2 is replaced at the same position, its successor
+		// doesn't inherit the record.
+		_, newPub := crypto.Generate(rand.Reader)
+		pubs := slices.Clone(s.pubs)
+		pubs[2] = newPub
+		rep.AddBlock(3, 0, pubs, dbft.DefaultPrimarySelector)
+		require.Equal(t, dbft.ValidatorStats{}, rep.Stats(newPub))
+		require.Equal(t, dbft.ValidatorStats{}, rep.Stats(s.pubs[2]))
+		require.EqualValues(t, 2, rep.PrimarySelector(4)(6, 0, pubs))
+
+		// Moved validator keeps its record.
+		rep.AddBlock(4, 1, pubs, dbft.DefaultPrimarySelector)
+		require.EqualValues(t, 1, rep.Stats(pubs[0]).Missed)
+		pubs[0], pubs[1] = pubs[1], pubs[0]
+		rep.AddBlock(6, 0, pubs, dbft.DefaultPrimarySelector)
+		require.EqualValues(t, 1, rep.Stats(pubs[1]).Missed)
+	})
+}
+
+func TestDBFT_ValidatorWeights(t *testing.T) {
+	s := newTestState(2, 4)
+	s.currHeight = 1
+	weights := dbft.WithValidatorWeights[crypto.Uint256](func(pubs []dbft.PublicKey) []uint64 {
+		require.Len(t, pubs, 4)
+		return []uint64{1, 1, 1, 5}
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(), weights)...)
+		require.NoError(t, err)
+		service.Start(0)
+		require.Equal(t, 8, service.TotalWeight())
+		require.Equal(t, 2, service.F())
+		require.Equal(t, 6, service.M())
+		require.Equal(t, 5, service.Weight(3))
+
+		req := s.tryRecv()
+		require.Equal(t, dbft.PrepareRequestType, req.Type())
+
+		// Two light validators are not enough.
+		service.OnReceive(s.getPrepareResponse(0, req.Hash(), 0))
+		service.OnReceive(s.getPrepareResponse(1, req.Hash(), 0))
+		require.Nil(t, s.tryRecv())
+
+		// The heavy one is.
+		service.OnReceive(s.getPrepareResponse(3, req.Hash(), 0))
+		require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+
+		require.NoError(t, service.Header().Sign(s.privs[3]))
+		service.OnReceive(s.getCommit(3, service.Header().Signature(), 0))
+		require.NotNil(t, s.nextBlock())
+		require.Equal(t, 6, service.CountCommitted())
+	})
+
+	t.Run("change view", func(t *testing.T) {
+		s := s.copyWithIndex(0)
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(), weights)...)
+		require.NoError(t, err)
+		service.Start(0)
+
+		service.OnReceive(s.getChangeView(1, 1))
+		service.OnReceive(s.getChangeView(2, 1))
+		require.EqualValues(t, 0, service.ViewNumber)
+		service.OnReceive(s.getChangeView(3, 1))
+		require.EqualValues(t, 1, service.ViewNumber)
+	})
+
+	t.Run("recovery responders", func(t *testing.T) {
+		// Validator 3 outweighs F, so it's the only one to respond to 2.
+		for i, responds := range []bool{false, false, false, true} {
+			s := s.copyWithIndex(i)
+			service, err := dbft.New[crypto.Uint256](append(s.getOptions(), weights)...)
+			require.NoError(t, err)
+			service.Start(0)
+			_ = s.tryRecv() // Flush the queue if primary.
+
+			service.OnReceive(s.getRecoveryRequest(2))
+			if responds {
+				require.Equal(t, dbft.RecoveryMessageType, s.tryRecv().Type())
+			} else {
+				require.Nil(t, s.tryRecv())
+			}
+		}
+	})
+}
+
+func TestDBFT_MaxViewNumber(t *testing.T) {
+	s := newTestState(0, 4)
+	s.currHeight = 1
+	var timeouts []dbft.View
+	service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+		dbft.WithMaxViewNumber[crypto.Uint256](1),
+		dbft.WithTimeoutPolicy[crypto.Uint256](recordingTimeout{dbft.DefaultTimeoutPolicy, &timeouts}))...)
+	require.NoError(t, err)
+	service.Start(0)
+	require.Equal(t, []dbft.View{0}, timeouts)
+
+	for i := range service.LastSeenMessage {
+		service.LastSeenMessage[i] = &dbft.HeightView{Height: s.currHeight + 1}
+	}
+	service.OnTimeout(s.currHeight+1, 0)
+	cv := s.tryRecv()
+	require.Equal(t, dbft.ChangeViewType, cv.Type())
+	require.EqualValues(t, 1, cv.GetChangeView().NewViewNumber())
+	service.OnReceive(s.getChangeView(1, 1))
+	service.OnReceive(s.getChangeView(2, 1))
+	require.EqualValues(t, 1, service.ViewNumber)
+	require.Equal(t, []dbft.View{0, 1, 1}, timeouts)
+
+	// No view change beyond the maximum.
+	for i := range service.LastSeenMessage {
+		service.LastSeenMessage[i] = &dbft.HeightView{Height: s.currHeight + 1, View: 1}
+	}
+	service.OnTimeout(s.currHeight+1, 1)
+	require.Equal(t, dbft.RecoveryRequestType, s.tryRecv().Type())
+	require.Nil(t, s.tryRecv())
+
+	for i := range 3 {
+		service.OnReceive(s.getChangeView(uint16(i+1), 2))
+	}
+	require.EqualValues(t, 1, service.ViewNumber)
+	require.Nil(t, s.tryRecv())
+}
+
+// byteTimer is a Timer implementation with single-byte views.
+type byteTimer struct {
+	*timer.Timer
+}
+
+func (t byteTimer) Reset(height uint32, view byte, d time.Duration) {
+	t.Timer.Reset(height, dbft.View(view), d)
+}
+
+func (t byteTimer) View() byte { return byte(t.Timer.View()) }
+
+func TestDBFT_ByteViewTimer(t *testing.T) {
+	s := newTestState(0, 4)
+	s.currHeight = 1
+	tt := byteTimer{timer.New()}
+
+	_, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+		dbft.WithTimer[crypto.Uint256](dbft.NewByteViewTimer(tt)),
+		dbft.WithMaxViewNumber[crypto.Uint256](dbft.DefaultMaxViewNumber+1))...)
+	require.Error(t, err)
+
+	service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+		dbft.WithTimer[crypto.Uint256](dbft.NewByteViewTimer(tt)))...)
+	require.NoError(t, err)
+	service.Start(0)
+	require.EqualValues(t, 2, tt.Height())
+	require.EqualValues(t, 0, tt.View())
+
+	for i := range 3 {
+		service.OnReceive(s.getChangeView(uint16(i+1), 1))
+	}
+	require.EqualValues(t, 1, tt.View())
+	require.EqualValues(t, 1, service.Timer.View())
+}
+
+// recordingTimeout records views ViewTimeout is requested for.
+type recordingTimeout struct {
+	dbft.TimeoutPolicy
+	views *[]dbft.View
+}
+
+func (r recordingTimeout) ViewTimeout(p dbft.TimeoutParams) time.Duration {
+	*r.views = append(*r.views, p.View)
+	return r.TimeoutPolicy.ViewTimeout(p)
+}
+
+func TestTimeoutPolicy(t *testing.T) {
+	p := func(view dbft.View) dbft.TimeoutParams {
+		return dbft.TimeoutParams{TimePerBlock: time.Second, View: view, RTT: 100 * time.Millisecond}
+	}
+
+	t.Run("exponential", func(t *testing.T) {
+		e := dbft.DefaultTimeoutPolicy
+		require.Equal(t, 2*time.Second, e.ViewTimeout(p(0)))
+		require.Equal(t, 8*time.Second, e.ViewTimeout(p(2)))
+		require.Equal(t, time.Second<<16, e.ViewTimeout(p(15)))
+		require.Equal(t, time.Second<<16, e.ViewTimeout(p(1000)))
+		require.Equal(t, time.Duration(math.MaxInt64), e.ViewTimeout(dbft.TimeoutParams{TimePerBlock: math.MaxInt64 >> 2, View: 1000}))
+		require.Equal(t, 4*time.Second, dbft.ExponentialTimeout{MaxShift: 2}.ViewTimeout(p(5)))
+
+		require.Equal(t, time.Second, e.CommitTimeout(p(0)))
+		require.Equal(t, 2*time.Second, e.CommitTimeout(dbft.TimeoutParams{TimePerBlock: time.Second, Retry: true}))
+	})
+
+	t.Run("linear", func(t *testing.T) {
+		l := dbft.LinearTimeout{Step: 100 * time.Millisecond, Max: 3 * time.Second}
+		require.Equal(t, 2*time.Second, l.ViewTimeout(p(0)))
+		require.Equal(t, 2500*time.Millisecond, l.ViewTimeout(p(5)))
+		require.Equal(t, 3*time.Second, l.ViewTimeout(p(100)))
+		require.Equal(t, 5*time.Second, dbft.LinearTimeout{}.ViewTimeout(p(3)))
+		require.Equal(t, time.Duration(math.MaxInt64), dbft.LinearTimeout{}.ViewTimeout(dbft.TimeoutParams{TimePerBlock: math.MaxInt64 >> 2, View: 1000}))
+		require.Equal(t, 2*time.Second+time.Second*math.MaxUint32, dbft.LinearTimeout{}.ViewTimeout(p(math.MaxUint32)))
+		require.Equal(t, time.Second, l.CommitTimeout(p(0)))
+	})
+
+	t.Run("jittered", func(t *testing.T) {
+		j := dbft.JitteredTimeout{Policy: dbft.DefaultTimeoutPolicy, Fraction: 0.5}
+		for range 10 {
+			v := j.ViewTimeout(p(0))
+			require.GreaterOrEqual(t, v, 2*time.Second)
+			require.Less(t, v, 3*time.Second)
+		}
+		require.Equal(t, time.Second, dbft.JitteredTimeout{}.CommitTimeout(p(0)))
+	})
+
+	t.Run("RTT-adaptive", func(t *testing.T) {
+		a := dbft.RTTAdaptiveTimeout{Policy: dbft.DefaultTimeoutPolicy, Factor: 3}
+		require.Equal(t, 2300*time.Millisecond, a.ViewTimeout(p(0)))
+		require.Equal(t, 1300*time.Millisecond, a.CommitTimeout(p(0)))
+		require.Equal(t, 2*time.Second, dbft.RTTAdaptiveTimeout{}.ViewTimeout(p(0)))
+	})
+
+	t.Run("MaxTimePerBlock", func(t *testing.T) {
+		s := newTestState(0, 4)
+		s.currHeight = 1
+		var params []dbft.TimeoutParams
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithMaxTimePerBlock[crypto.Uint256](func() time.Duration { return 30 * time.Second }),
+			dbft.WithSubscribeForTxs[crypto.Uint256](func() {}),
+			dbft.WithTimeoutPolicy[crypto.Uint256](paramsTimeout{dbft.DefaultTimeoutPolicy, &params}))...)
+		require.NoError(t, err)
+		service.Start(0)
+		require.Len(t, params, 1)
+
+		// Backup waits for transactions longer, extension is computed by
+		// the policy for MaxTimePerBlock.
+		service.OnTimeout(s.currHeight+1, 0)
+		require.Nil(t, s.tryRecv())
+		require.Len(t, params, 3)
+		require.Equal(t, 30*time.Second, params[1].TimePerBlock)
+		require.Equal(t, 10*time.Second, params[2].TimePerBlock)
+
+		service.OnNewTransaction()
+		require.Nil(t, s.tryRecv())
+		require.Len(t, params, 4)
+		require.Equal(t, 10*time.Second, params[3].TimePerBlock)
+	})
+}
+
+// paramsTimeout records parameters ViewTimeout is requested with.
+type paramsTimeout struct {
+	dbft.TimeoutPolicy
+	params *[]dbft.TimeoutParams
+}
+
+func (r paramsTimeout) ViewTimeout(p dbft.TimeoutParams) time.Duration {
+	*r.params = append(*r.params, p)
+	return r.TimeoutPolicy.ViewTimeout(p)
+}
+
+// recordingRTT records validators RTT is measured for.
+type recordingRTT struct {
+	*dbft.RTTStats
+	kinds []dbft.RTTKind
+	from  []dbft.PublicKey
+}
+
+func (r *recordingRTT) Add(kind dbft.RTTKind, validator dbft.PublicKey, t time.Duration) {
+	r.kinds = append(r.kinds, kind)
+	r.from = append(r.from, validator)
+	r.RTTStats.Add(kind, validator, t)
+}
+
+func TestDBFT_RTTEstimator(t *testing.T) {
+	t.Run("primary", func(t *testing.T) {
+		s := newTestState(2, 4)
+		s.currHeight = 1
+		rtt := &recordingRTT{RTTStats: dbft.NewRTTStats(0)}
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(), dbft.WithRTTEstimator[crypto.Uint256](rtt))...)
+		require.NoError(t, err)
+		service.Start(0)
+
+		req := s.tryRecv()
+		service.OnReceive(s.getPrepareResponse(1, req.Hash(), 0))
+		service.OnReceive(s.getPrepareResponse(0, req.Hash(), 0))
+		require.Equal(t, []dbft.PublicKey{s.pubs[1], s.pubs[0]}, rtt.from)
+		require.Equal(t, []dbft.RTTKind{dbft.PrepareRTT, dbft.PrepareRTT}, rtt.kinds)
+		require.Zero(t, rtt.Average(dbft.CommitRTT))
+	})
+
+	t.Run("backup", func(t *testing.T) {
+		s := newTestState(0, 4)
+		s.currHeight = 1
+		rtt := &recordingRTT{RTTStats: dbft.NewRTTStats(0)}
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(), dbft.WithRTTEstimator[crypto.Uint256](rtt))...)
+		require.NoError(t, err)
+		service.Start(0)
+
+		// Commit before PrepareRequest isn't measured.
+		service.OnReceive(s.getCommit(3, make([]byte, 64), 0))
+		tx := testTx(1)
+		s.pool.Add(tx)
+		service.OnReceive(s.getPrepareRequest(2, tx.Hash()))
+		require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+		require.Nil(t, rtt.from)
+
+		service.OnReceive(s.getPrepareResponse(1, service.PreparationPayloads[2].Hash(), 0))
+		require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+		require.NoError(t, service.Header().Sign(s.privs[1]))
+		service.OnReceive(s.getCommit(1, service.Header().Signature(), 0))
+		require.Equal(t, []dbft.PublicKey{s.pubs[1]}, rtt.from)
+		require.Equal(t, []dbft.RTTKind{dbft.CommitRTT}, rtt.kinds)
+		require.NotZero(t, rtt.Estimate(dbft.CommitRTT, s.pubs[1]))
+		// Backup measurements are kept separately.
+		require.Zero(t, rtt.Average(dbft.PrepareRTT))
+		require.Zero(t, rtt.Estimate(dbft.PrepareRTT, s.pubs[1]))
+	})
+
+	t.Run("timer adjustment", func(t *testing.T) {
+		for _, tc := range []struct {
+			name    string
+			myIndex int
+			seed    []dbft.RTTKind
+			rtt     time.Duration
+		}{
+			{"backup", 0, []dbft.RTTKind{dbft.PrepareRTT, dbft.CommitRTT}, 200 * time.Millisecond},
+			{"backup without CommitRTT", 0, []dbft.RTTKind{dbft.PrepareRTT}, 100 * time.Millisecond},
+			{"primary", 2, []dbft.RTTKind{dbft.PrepareRTT, dbft.CommitRTT}, 100 * time.Millisecond},
+			{"primary without PrepareRTT", 2, []dbft.RTTKind{dbft.CommitRTT}, 200 * time.Millisecond},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				s := newTestState(tc.myIndex, 4)
+				s.currHeight = 1
+				rtt := dbft.NewRTTStats(0)
+				for _, k := range tc.seed {
+					rtt.Seed(k, []dbft.ValidatorRTT{{Key: s.pubs[1], RTT: time.Duration(k+1) * 100 * time.Millisecond}})
+				}
+				var params []dbft.TimeoutParams
+				service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+					dbft.WithRTTEstimator[crypto.Uint256](rtt),
+					dbft.WithTimeoutPolicy[crypto.Uint256](paramsTimeout{dbft.DefaultTimeoutPolicy, &params}))...)
+				require.NoError(t, err)
+				service.Start(0)
+				if tc.myIndex == 2 {
+					// Primary view timeout is used once it sends
+					// PrepareRequest.
+					service.OnTimeout(s.currHeight+1, 0)
+					require.Equal(t, dbft.PrepareRequestType, s.tryRecv().Type())
+				}
+				require.NotEmpty(t, params)
+				require.Equal(t, tc.rtt, params[0].RTT)
+			})
+		}
+	})
+}
+
+func TestDBFT_ClockSkew(t *testing.T) {
+	s := newTestState(0, 4)
+	s.currHeight = 1
+	var skewed []int
+	service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+		dbft.WithMaxClockSkew[crypto.Uint256](time.Minute),
+		dbft.WithOnClockSkew[crypto.Uint256](func(validator int, offset time.Duration) {
+			require.Greater(t, offset, time.Minute)
+			skewed = append(skewed, validator)
+		}),
+		dbft.WithMaxTimestampDrift[crypto.Uint256](time.Hour))...)
+	require.NoError(t, err)
+	service.Start(0)
+
+	changeView := func(from uint16, view dbft.View, ts time.Time) Payload {
+		cv := consensus.NewChangeView(view, 0, uint64(ts.UnixNano()))
+		return consensus.NewConsensusPayload(dbft.ChangeViewType, s.currHeight+1, from, 0, cv)
+	}
+
+	service.OnReceive(changeView(1, 1, time.Now()))
+	require.Less(t, service.ClockOffset(1), 2*time.Second)
+	require.Greater(t, service.ClockOffset(1), -2*time.Second)
+
+	service.OnReceive(changeView(2, 1, time.Now().Add(10*time.Minute)))
+	require.Greater(t, service.ClockOffset(2), 9*time.Minute)
+	require.Equal(t, []int{2}, skewed)
+
+	rr := consensus.NewConsensusPayload(dbft.RecoveryRequestType, s.currHeight+1, 2, 0,
+		consensus.NewRecoveryRequest(uint64(time.Now().Add(10*time.Minute).UnixNano())))
+	service.OnReceive(rr)
+	require.Equal(t, dbft.RecoveryMessageType, s.tryRecv().Type())
+	require.Equal(t, []int{2}, skewed) // Reported once.
+	require.Zero(t, service.ClockOffset(3))
+
+	t.Run("PrepareRequest drift", func(t *testing.T) {
+		tx := testTx(1)
+		s.pool.Add(tx)
+		prepareRequest := func(ts time.Time) Payload {
+			req := consensus.NewPrepareRequest(uint64(ts.UnixNano()), 0, []crypto.Uint256{tx.Hash()})
+			return consensus.NewConsensusPayload(dbft.PrepareRequestType, s.currHeight+1, 2, 0, req)
+		}
+
+		service.OnReceive(prepareRequest(time.Now().Add(2 * time.Hour)))
+		require.False(t, service.RequestSentOrReceived())
+		cv := s.tryRecv()
+		require.NotNil(t, cv)
+		require.Equal(t, dbft.ChangeViewType, cv.Type())
+
+		service.Reset(0)
+		service.OnReceive(prepareRequest(time.Now().Add(30 * time.Minute)))
+		require.True(t, service.RequestSentOrReceived())
+		require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+	})
+
+	t.Run("validators change", func(t *testing.T) {
+		var (
+			old     = s.pubs
+			offset1 = service.ClockOffset(1)
+		)
+		require.NotZero(t, offset1)
+		require.Greater(t, service.ClockOffset(2), 9*time.Minute)
+
+		// Validator 2 is replaced, validators 1 and 3 swap places.
+		_, newPub := crypto.Generate(rand.Reader)
+		s.pubs = []dbft.PublicKey{old[0], old[3], newPub, old[1]}
+		defer func() { s.pubs = old }()
+		service.Reset(0)
+		require.Zero(t, service.ClockOffset(2))
+		require.Zero(t, service.ClockOffset(1))
+		require.Equal(t, offset1, service.ClockOffset(3))
+	})
+}
+
+func TestDBFT_Pipelined(t *testing.T) {
+	s := newTestState(0, 1)
+	s.currHeight = 1
+	service, err := dbft.New[crypto.Uint256](append(s.getOptions(), dbft.WithPipelining[crypto.Uint256](true))...)
+	require.NoError(t, err)
+
+	service.Start(0)
+	require.Equal(t, dbft.PrepareRequestType, s.tryRecv().Type())
+	require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+	b2 := s.nextBlock()
+	require.NotNil(t, b2)
+	require.EqualValues(t, 2, b2.Index())
+
+	// Height 3 is started on top of the approved block.
+	require.EqualValues(t, 3, service.BlockIndex)
+	require.Equal(t, b2.Hash(), service.PrevHash)
+	require.False(t, service.BlockSent())
+
+	// RecoveryRequest for the pending block height is still answered.
+	service.OnReceive(s.getRecoveryRequest(0))
+	rm := s.tryRecv()
+	require.Equal(t, dbft.RecoveryMessageType, rm.Type())
+	require.EqualValues(t, 2, rm.Height())
+	require.Len(t, rm.GetRecoveryMessage().GetCommits(rm, service.Validators), 1)
+
+	// Block 3 is proposed, but not committed until block 2 is persisted.
+	service.OnTimeout(3, 0)
+	p := s.tryRecv()
+	require.Equal(t, dbft.PrepareRequestType, p.Type())
+	require.EqualValues(t, 3, p.Height())
+	require.Nil(t, s.tryRecv())
+	require.False(t, service.CommitSent())
+
+	// Block 2 is persisted, block 3 is committed and height 4 is started
+	// on top of it.
+	s.currHeight, s.currHash = 2, b2.Hash()
+	service.Reset(0)
+	require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+	b3 := s.nextBlock()
+	require.NotNil(t, b3)
+	require.Equal(t, b2.Hash(), b3.PrevHash())
+	require.EqualValues(t, 4, service.BlockIndex)
+	require.Equal(t, b3.Hash(), service.PrevHash)
+
+	t.Run("rollback", func(t *testing.T) {
+		// Block 3 can't be persisted.
+		service.Rollback(0)
+		require.EqualValues(t, 3, service.BlockIndex)
+		require.Equal(t, b2.Hash(), service.PrevHash)
+		require.False(t, service.BlockSent())
+
+		// Commit is kept and resent, the node asks for recovery.
+		require.True(t, service.CommitSent())
+		require.Equal(t, dbft.RecoveryRequestType, s.tryRecv().Type())
+		require.Equal(t, dbft.RecoveryMessageType, s.tryRecv().Type())
+		require.Nil(t, s.tryRecv())
+
+		// Nothing to roll back.
+		service.Rollback(0)
+		require.EqualValues(t, 3, service.BlockIndex)
+	})
+
+	t.Run("different block persisted", func(t *testing.T) {
+		// The same block is approved again on timeout.
+		service.OnTimeout(3, 0)
+		require.Equal(t, dbft.RecoveryMessageType, s.tryRecv().Type())
+		require.Equal(t, b3.Hash(), s.nextBlock().Hash())
+		require.EqualValues(t, 4, service.BlockIndex)
+
+		s.currHeight, s.currHash = 3, crypto.Uint256{1, 2, 3}
+		service.Reset(0)
+		require.EqualValues(t, 4, service.BlockIndex)
+		require.Equal(t, s.currHash, service.PrevHash)
+	})
+}
+
+func TestDBFT_PipelinedRollback(t *testing.T) {
+	s := newTestState(1, 4)
+	s.currHeight = 1
+	service, err := dbft.New[crypto.Uint256](append(s.getOptions(), dbft.WithPipelining[crypto.Uint256](true))...)
+	require.NoError(t, err)
+	service.Start(0)
+
+	tx := testTx(1)
+	s.pool.Add(tx)
+	req := s.getPrepareRequest(2, tx.Hash())
+	service.OnReceive(req)
+	require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+	service.OnReceive(s.getPrepareResponse(0, req.Hash(), 0))
+	commit := s.tryRecv()
+	require.Equal(t, dbft.CommitType, commit.Type())
+	for _, i := range []int{0, 2} {
+		require.NoError(t, service.Header().Sign(s.privs[i]))
+		service.OnReceive(s.getCommit(uint16(i), service.Header().Signature(), 0))
+	}
+	b := s.nextBlock()
+	require.NotNil(t, b)
+	require.EqualValues(t, 3, service.BlockIndex)
+
+	// Block 3 is prepared, but not committed while block 2 is pending, so
+	// there is nothing to lose on rollback.
+	s3 := *s
+	s3.currHeight = 2
+	tx3 := testTx(3)
+	s.pool.Add(tx3)
+	req3 := s3.getPrepareRequest(3, tx3.Hash())
+	service.OnReceive(req3)
+	require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+	for _, i := range []uint16{0, 2} {
+		service.OnReceive(s3.getPrepareResponse(i, req3.Hash(), 0))
+	}
+	require.Nil(t, s.tryRecv())
+	require.False(t, service.CommitSent())
+
+	service.Rollback(0)
+	require.EqualValues(t, 2, service.BlockIndex)
+	require.EqualValues(t, 0, service.ViewNumber)
+	require.Equal(t, commit.Hash(), service.CommitPayloads[1].Hash())
+	require.Equal(t, dbft.RecoveryRequestType, s.tryRecv().Type())
+	rm := s.tryRecv()
+	require.Equal(t, dbft.RecoveryMessageType, rm.Type())
+	require.Len(t, rm.GetRecoveryMessage().GetCommits(rm, service.Validators), 3)
+
+	// Another proposal can't be signed at this height.
+	service.OnReceive(s.getPrepareRequest(2, testTx(2).Hash()))
+	require.Nil(t, s.tryRecv())
+
+	// Block is approved again on timeout.
+	service.OnTimeout(2, 0)
+	require.Equal(t, dbft.RecoveryMessageType, s.tryRecv().Type())
+	require.Equal(t, b.Hash(), s.nextBlock().Hash())
+	require.EqualValues(t, 3, service.BlockIndex)
+
+	// Another block 3 is proposed and committed once block 2 is persisted,
+	// it's the only Commit sent at this height.
+	tx4 := testTx(4)
+	s.pool.Add(tx4)
+	req3 = s3.getPrepareRequest(3, tx4.Hash())
+	service.OnReceive(req3)
+	require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+	for _, i := range []uint16{0, 2} {
+		service.OnReceive(s3.getPrepareResponse(i, req3.Hash(), 0))
+	}
+	require.Nil(t, s.tryRecv())
+	s.currHeight, s.currHash = 2, b.Hash()
+	service.Reset(0)
+	commit3 := s.tryRecv()
+	require.Equal(t, dbft.CommitType, commit3.Type())
+	require.EqualValues(t, 3, commit3.Height())
+	require.Nil(t, s.tryRecv())
+}
+
+func TestDBFT_PipelinedRecoveryRequest(t *testing.T) {
+	type event struct {
+		validator int
+		typ       dbft.MessageType
+	}
+	// newService returns the service of node 1 with block 2 approved, but
+	// not persisted.
+	newService := func(t *testing.T, s *testState, opts ...func(*dbft.Config[crypto.Uint256])) *dbft.DBFT[crypto.Uint256] {
+		service, err := dbft.New[crypto.Uint256](append(append(s.getOptions(), dbft.WithPipelining[crypto.Uint256](true)), opts...)...)
+		require.NoError(t, err)
+		service.Start(0)
+
+		tx := testTx(1)
+		s.pool.Add(tx)
+		req := s.getPrepareRequest(2, tx.Hash())
+		service.OnReceive(req)
+		service.OnReceive(s.getPrepareResponse(0, req.Hash(), 0))
+		for _, i := range []int{0, 2} {
+			require.NoError(t, service.Header().Sign(s.privs[i]))
+			service.OnReceive(s.getCommit(uint16(i), service.Header().Signature(), 0))
+		}
+		require.NotNil(t, s.nextBlock())
+		require.EqualValues(t, 3, service.BlockIndex)
+		s.ch = nil
+		return service
+	}
+	rr := func(from uint16, ts uint64) Payload {
+		return consensus.NewConsensusPayload(dbft.RecoveryRequestType, 2, from, 0, consensus.NewRecoveryRequest(ts*uint64(time.Second)))
+	}
+
+	t.Run("rate limit", func(t *testing.T) {
+		s := newTestState(1, 4)
+		s.currHeight = 1
+		var events []event
+		service := newService(t, s,
+			dbft.WithRecoveryRequestRateLimit[crypto.Uint256](dbft.RateLimit{Rate: 0.001, Burst: 1}),
+			dbft.WithOnRateLimited[crypto.Uint256](func(validator int, typ dbft.MessageType) {
+				events = append(events, event{validator, typ})
+			}))
+
+		service.OnReceive(rr(0, 1))
+		rm := s.tryRecv()
+		require.Equal(t, dbft.RecoveryMessageType, rm.Type())
+		require.EqualValues(t, 2, rm.Height())
+		service.OnReceive(rr(0, 2))
+		require.Nil(t, s.tryRecv())
+		require.Equal(t, []event{{0, dbft.RecoveryRequestType}}, events)
+	})
+
+	t.Run("async verification", func(t *testing.T) {
+		s := newTestState(1, 4)
+		s.currHeight = 1
+		var (
+			service  *dbft.DBFT[crypto.Uint256]
+			requests []*dbft.VerificationTask[crypto.Uint256]
+			verified int
+		)
+		service = newService(t, s,
+			dbft.WithVerifyRecoveryRequest[crypto.Uint256](func(Payload) error {
+				verified++
+				return nil
+			}),
+			dbft.WithVerifyAsync[crypto.Uint256](func(task *dbft.VerificationTask[crypto.Uint256]) {
+				// Only RecoveryRequest verification is delayed.
+				if task.Payload != nil && task.Payload.Type() == dbft.RecoveryRequestType {
+					requests = append(requests, task)
+					return
+				}
+				service.OnVerificationResult(task, task.Verify())
+			}))
+
+		service.OnReceive(rr(0, 1))
+		require.Nil(t, s.tryRecv())
+		require.Len(t, requests, 1)
+		require.Zero(t, verified)
+
+		require.NoError(t, requests[0].Verify())
+		require.Equal(t, 1, verified)
+		service.OnVerificationResult(requests[0], nil)
+		rm := s.tryRecv()
+		require.Equal(t, dbft.RecoveryMessageType, rm.Type())
+		require.EqualValues(t, 2, rm.Height())
+
+		// Invalid requests are not answered.
+		service.OnReceive(rr(0, 2))
+		require.Len(t, requests, 2)
+		service.OnVerificationResult(requests[1], errors.New("invalid"))
+		require.Nil(t, s.tryRecv())
+	})
+}
+
+func TestDBFT_FastPath(t *testing.T) {
+	newService := func(t *testing.T, s *testState, h int64) *dbft.DBFT[crypto.Uint256] {
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(), dbft.WithFastPathEnablingHeight[crypto.Uint256](h))...)
+		require.NoError(t, err)
+		service.Start(0)
+		return service
+	}
+
+	t.Run("all prepared", func(t *testing.T) {
+		s := newTestState(2, 4)
+		s.currHeight = 1
+		service := newService(t, s, 2)
+
+		req := s.tryRecv()
+		require.Equal(t, dbft.PrepareRequestType, req.Type())
+		header := service.Header()
+		sig := req.GetPrepareRequest().(dbft.SignedPreparation).Signature()
+		require.NoError(t, header.(dbft.PreparationSigner).VerifyPreparation(s.pubs[2], sig))
+		require.Error(t, header.Verify(s.pubs[2], sig))
+		service.OnReceive(s.getSignedPrepareResponse(t, 1, req.Hash(), header))
+		service.OnReceive(s.getSignedPrepareResponse(t, 0, req.Hash(), header))
+		require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+		require.Nil(t, s.nextBlock())
+
+		service.OnReceive(s.getSignedPrepareResponse(t, 3, req.Hash(), header))
+		b := s.nextBlock()
+		require.NotNil(t, b)
+		require.Equal(t, service.Header().Hash(), b.Hash())
+		require.True(t, service.FastPathApproved)
+		require.True(t, service.BlockSent())
+	})
+
+	t.Run("invalid preparation signature", func(t *testing.T) {
+		s := newTestState(2, 4)
+		s.currHeight = 1
+		service := newService(t, s, 2)
+
+		req := s.tryRecv()
+		header := service.Header()
+		service.OnReceive(s.getSignedPrepareResponse(t, 1, req.Hash(), header))
+		service.OnReceive(s.getSignedPrepareResponse(t, 0, req.Hash(), header))
+		require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+
+		// Signed by another validator.
+		resp := s.getSignedPrepareResponse(t, 0, req.Hash(), header)
+		resp.SetValidatorIndex(3)
+		service.OnReceive(resp)
+		require.Nil(t, s.nextBlock())
+		require.False(t, service.FastPathApproved)
+	})
+
+	t.Run("unsigned preparation", func(t *testing.T) {
+		s := newTestState(2, 4)
+		s.currHeight = 1
+		service := newService(t, s, 2)
+
+		req := s.tryRecv()
+		header := service.Header()
+		service.OnReceive(s.getSignedPrepareResponse(t, 1, req.Hash(), header))
+		service.OnReceive(s.getSignedPrepareResponse(t, 0, req.Hash(), header))
+		require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+
+		service.OnReceive(s.getPrepareResponse(3, req.Hash(), 0))
+		require.Nil(t, s.nextBlock())
+		require.False(t, service.FastPathApproved)
+
+		// Block is still approved via Commits.
+		require.NoError(t, header.Sign(s.privs[0]))
+		service.OnReceive(s.getCommit(0, header.Signature(), 0))
+		require.NoError(t, header.Sign(s.privs[1]))
+		service.OnReceive(s.getCommit(1, header.Signature(), 0))
+		require.NotNil(t, s.nextBlock())
+	})
+
+	t.Run("preparation signatures as Commits", func(t *testing.T) {
+		s := newTestState(2, 4)
+		s.currHeight = 1
+		service := newService(t, s, 2)
+
+		req := s.tryRecv()
+		header := service.Header()
+		service.OnReceive(s.getSignedPrepareResponse(t, 1, req.Hash(), header))
+		service.OnReceive(s.getSignedPrepareResponse(t, 0, req.Hash(), header))
+		require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+
+		// Preparation signatures are never valid block signatures.
+		service.OnReceive(s.getCommit(0, s.getPreparationSignature(t, 0, header), 0))
+		service.OnReceive(s.getCommit(1, s.getPreparationSignature(t, 1, header), 0))
+		require.Nil(t, s.nextBlock())
+		require.Nil(t, service.CommitPayloads[0])
+		require.Nil(t, service.CommitPayloads[1])
+	})
+
+	t.Run("disabled at height", func(t *testing.T) {
+		s := newTestState(2, 4)
+		s.currHeight = 1
+		service := newService(t, s, 3)
+
+		req := s.tryRecv()
+		for _, i := range []uint16{0, 1, 3} {
+			service.OnReceive(s.getPrepareResponse(i, req.Hash(), 0))
+		}
+		require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+		require.Nil(t, s.nextBlock())
+		require.False(t, service.FastPathApproved)
+	})
+
+	t.Run("change view lock", func(t *testing.T) {
+		s := newTestState(0, 4)
+		s.currHeight = 1
+		service := newService(t, s, 0)
+
+		tx := testTx(1)
+		s.pool.Add(tx)
+		service.OnReceive(s.getPrepareRequest(2, tx.Hash()))
+		require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+
+		// Preparation is sent, so no ChangeView on timeout.
+		service.OnTimeout(s.currHeight+1, 0)
+		require.Equal(t, dbft.RecoveryMessageType, s.tryRecv().Type())
+		require.False(t, service.ViewChanging())
+
+		// F validators requesting view change aren't enough to unlock.
+		service.OnReceive(s.getChangeView(1, 1))
+		service.OnTimeout(s.currHeight+1, 0)
+		require.Equal(t, dbft.RecoveryMessageType, s.tryRecv().Type())
+
+		service.OnReceive(s.getChangeView(3, 1))
+		service.OnTimeout(s.currHeight+1, 0)
+		require.Equal(t, dbft.ChangeViewType, s.tryRecv().Type())
+		require.EqualValues(t, 1, service.ViewNumber)
+	})
+
+	t.Run("no preparation after change view", func(t *testing.T) {
+		s := newTestState(0, 4)
+		s.currHeight = 1
+		service := newService(t, s, 0)
+
+		// Make other nodes seen to allow ChangeView.
+		service.OnReceive(s.getRecoveryRequest(1))
+		service.OnReceive(s.getRecoveryRequest(3))
+		for s.tryRecv() != nil {
+		}
+		service.OnTimeout(s.currHeight+1, 0)
+		require.Equal(t, dbft.ChangeViewType, s.tryRecv().Type())
+
+		// More than F nodes committed, but PrepareResponse isn't sent.
+		service.OnReceive(s.getCommit(1, make([]byte, 64), 0))
+		service.OnReceive(s.getCommit(3, make([]byte, 64), 0))
+		service.OnReceive(s.getPrepareRequest(2))
+		require.Nil(t, s.tryRecv())
+		require.False(t, service.ResponseSent())
+	})
+}
+
+func TestDBFT_FinalityProof(t *testing.T) {
+	validatorsHash := func(pubs []dbft.PublicKey) crypto.Uint256 {
+		var b []byte
+		for _, p := range pubs {
+			b = append(b, p.(*crypto.ECDSAPub).X.Bytes()...)
+		}
+		return crypto.Hash256(b)
+	}
+
+	t.Run("config", func(t *testing.T) {
+		s := newTestState(2, 4)
+		_, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithProcessFinalityProof[crypto.Uint256](func(*dbft.FinalityProof[crypto.Uint256]) {}))...)
+		require.Error(t, err)
+	})
+
+	s := newTestState(2, 4)
+	s.currHeight = 1
+	var proof *dbft.FinalityProof[crypto.Uint256]
+	service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+		dbft.WithValidatorsHash[crypto.Uint256](validatorsHash),
+		dbft.WithProcessFinalityProof[crypto.Uint256](func(p *dbft.FinalityProof[crypto.Uint256]) { proof = p }))...)
+	require.NoError(t, err)
+	service.Start(0)
+
+	req := s.tryRecv()
+	service.OnReceive(s.getPrepareResponse(1, req.Hash(), 0))
+	service.OnReceive(s.getPrepareResponse(0, req.Hash(), 0))
+	require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+	require.NoError(t, service.Header().Sign(s.privs[0]))
+	service.OnReceive(s.getCommit(0, service.Header().Signature(), 0))
+	require.Nil(t, proof)
+	require.NoError(t, service.Header().Sign(s.privs[3]))
+	service.OnReceive(s.getCommit(3, service.Header().Signature(), 0))
+
+	b := s.nextBlock()
+	require.NotNil(t, b)
+	require.NotNil(t, proof)
+	require.Equal(t, b.Hash(), proof.BlockHash)
+	require.EqualValues(t, 2, proof.Height)
+	require.EqualValues(t, 0, proof.View)
+	require.Equal(t, []uint16{0, 2, 3}, proof.Signers)
+	require.NoError(t, dbft.VerifyFinalityProof(proof, b, s.pubs, validatorsHash, nil))
+
+	t.Run("weights", func(t *testing.T) {
+		require.NoError(t, dbft.VerifyFinalityProof(proof, b, s.pubs, validatorsHash, []uint64{1, 1, 1, 1}))
+		require.Error(t, dbft.VerifyFinalityProof(proof, b, s.pubs, validatorsHash, []uint64{1, 5, 1, 1}))
+		require.Error(t, dbft.VerifyFinalityProof(proof, b, s.pubs, validatorsHash, []uint64{1, 1}))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		require.Error(t, dbft.VerifyFinalityProof(nil, b, s.pubs, validatorsHash, nil))
+		require.Error(t, dbft.VerifyFinalityProof(proof, b, slices.Clone(s.pubs[:3]), validatorsHash, nil))
+
+		p := *proof
+		p.Signers, p.Signatures = p.Signers[:2], p.Signatures[:2]
+		require.Error(t, dbft.VerifyFinalityProof(&p, b, s.pubs, validatorsHash, nil))
+
+		p = *proof
+		p.Signers = []uint16{0, 1, 3}
+		require.Error(t, dbft.VerifyFinalityProof(&p, b, s.pubs, validatorsHash, nil))
+
+		p = *proof
+		p.Signers = []uint16{0, 0, 3}
+		require.Error(t, dbft.VerifyFinalityProof(&p, b, s.pubs, validatorsHash, nil))
+
+		p = *proof
+		p.Height++
+		require.Error(t, dbft.VerifyFinalityProof(&p, b, s.pubs, validatorsHash, nil))
+	})
+
+	t.Run("fast path", func(t *testing.T) {
+		s := newTestState(2, 4)
+		s.currHeight = 1
+		var proof *dbft.FinalityProof[crypto.Uint256]
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithFastPathEnablingHeight[crypto.Uint256](0),
+			dbft.WithValidatorsHash[crypto.Uint256](validatorsHash),
+			dbft.WithProcessFinalityProof[crypto.Uint256](func(p *dbft.FinalityProof[crypto.Uint256]) { proof = p }))...)
+		require.NoError(t, err)
+		service.Start(0)
+
+		req := s.tryRecv()
+		header := service.Header()
+		for _, i := range []uint16{0, 1, 3} {
+			service.OnReceive(s.getSignedPrepareResponse(t, i, req.Hash(), header))
+		}
+
+		b := s.nextBlock()
+		require.NotNil(t, b)
+		require.True(t, service.FastPathApproved)
+		require.NotNil(t, proof)
+		require.Equal(t, []uint16{0, 1, 2, 3}, proof.Signers)
+		require.True(t, proof.FastPath)
+		require.NoError(t, dbft.VerifyFinalityProof(proof, b, s.pubs, validatorsHash, nil))
+
+		// Preparation signatures can't be used as Commit ones.
+		p := *proof
+		p.FastPath = false
+		require.Error(t, dbft.VerifyFinalityProof(&p, b, s.pubs, validatorsHash, nil))
+
+		// M preparation signatures are not enough.
+		p = *proof
+		p.Signers, p.Signatures = p.Signers[:3], p.Signatures[:3]
+		require.Error(t, dbft.VerifyFinalityProof(&p, b, s.pubs, validatorsHash, nil))
+
+		// Zero-weight validators are not required to sign.
+		require.NoError(t, dbft.VerifyFinalityProof(&p, b, s.pubs, validatorsHash, []uint64{1, 1, 1, 0}))
+
+		// Commit signatures can't be used as preparation ones.
+		p = *proof
+		p.Signatures = nil
+		for _, i := range p.Signers {
+			require.NoError(t, b.Sign(s.privs[i]))
+			p.Signatures = append(p.Signatures, b.Signature())
+		}
+		require.Error(t, dbft.VerifyFinalityProof(&p, b, s.pubs, validatorsHash, nil))
+		p.FastPath = false
+		require.NoError(t, dbft.VerifyFinalityProof(&p, b, s.pubs, validatorsHash, nil))
+	})
+}
+
+func TestDBFT_Observer(t *testing.T) {
+	validatorsHash := func(pubs []dbft.PublicKey) crypto.Uint256 {
+		return crypto.Hash256(fmt.Appendf(nil, "%d", len(pubs)))
+	}
+
+	s := newTestState(0, 4)
+	s.currHeight = 1
+	var proofs []*dbft.FinalityProof[crypto.Uint256]
+	service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+		dbft.WithGetKeyPair[crypto.Uint256](nil),
+		dbft.WithObserver[crypto.Uint256](true),
+		dbft.WithValidatorsHash[crypto.Uint256](validatorsHash),
+		dbft.WithProcessFinalityProof[crypto.Uint256](func(p *dbft.FinalityProof[crypto.Uint256]) { proofs = append(proofs, p) }))...)
+	require.NoError(t, err)
+	service.Start(0)
+	require.True(t, service.Context.WatchOnly())
+
+	// Commits are received before PrepareRequest and transactions.
+	tx := testTx(1)
+	header := consensus.NewBlock(0, 2, s.currHash, 0, []crypto.Uint256{tx.Hash()})
+	header.SetTransactions([]dbft.Transaction[crypto.Uint256]{tx})
+	for _, i := range []uint16{0, 1, 3} {
+		require.NoError(t, header.Sign(s.privs[i]))
+		service.OnReceive(s.getCommit(i, header.Signature(), 0))
+	}
+	service.OnReceive(s.getPrepareRequest(2, tx.Hash()))
+	require.Nil(t, s.nextBlock())
+	service.OnTransaction(tx)
+
+	b2 := s.nextBlock()
+	require.NotNil(t, b2)
+	require.Equal(t, header.Hash(), b2.Hash())
+	require.Len(t, proofs, 1)
+	require.NoError(t, dbft.VerifyFinalityProof(proofs[0], b2, s.pubs, validatorsHash, nil))
+
+	// The next height is started without ledger update.
+	require.EqualValues(t, 3, service.BlockIndex)
+	require.Equal(t, b2.Hash(), service.PrevHash)
+
+	// Block 3 is recovered via RecoveryMessage from the primary.
+	v := newTestState(3, 4)
+	v.privs, v.pubs = s.privs, s.pubs
+	v.currHeight, v.currHash = 2, b2.Hash()
+	primary, err := dbft.New[crypto.Uint256](v.getOptions()...)
+	require.NoError(t, err)
+	primary.Start(0)
+	v.pool.Add(tx)
+	req := v.tryRecv()
+	require.Equal(t, dbft.PrepareRequestType, req.Type())
+	primary.OnReceive(v.getPrepareResponse(0, req.Hash(), 0))
+	primary.OnReceive(v.getPrepareResponse(1, req.Hash(), 0))
+	require.Equal(t, dbft.CommitType, v.tryRecv().Type())
+	primary.OnTimeout(3, 0)
+	rm := v.tryRecv()
+	require.Equal(t, dbft.RecoveryMessageType, rm.Type())
+
+	service.OnReceive(rm)
+	require.Nil(t, s.nextBlock())
+	for _, i := range []uint16{0, 1} {
+		require.NoError(t, primary.Header().Sign(s.privs[i]))
+		service.OnReceive(v.getCommit(i, primary.Header().Signature(), 0))
+	}
+	b3 := s.nextBlock()
+	require.NotNil(t, b3)
+	require.Equal(t, primary.CreateBlock().Hash(), b3.Hash())
+	require.Len(t, proofs, 2)
+	require.NoError(t, dbft.VerifyFinalityProof(proofs[1], b3, s.pubs, validatorsHash, nil))
+	require.EqualValues(t, 4, service.BlockIndex)
+
+	// Nothing is ever sent by observer.
+	require.Nil(t, s.tryRecv())
+}
+
+// clockTimer is a Timer with manually advanced time.
+type clockTimer struct {
+	*timer.Timer
+	now time.Time
+}
+
+func (t *clockTimer) Now() time.Time { return t.now }
+
+func TestDBFT_TxFetcher(t *testing.T) {
+	t.Run("invalid config", func(t *testing.T) {
+		s := newTestState(0, 4)
+		_, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithTxRetry[crypto.Uint256](time.Second, time.Millisecond))...)
+		require.Error(t, err)
+	})
+
+	type fetched struct {
+		hash     crypto.Uint256
+		latency  time.Duration
+		attempts int
+	}
+	var (
+		s       = newTestState(0, 4)
+		tt      = &clockTimer{Timer: timer.New(), now: time.Unix(1000, 0)}
+		from    []int
+		results []fetched
+	)
+	s.currHeight = 1
+	service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+		dbft.WithTimer[crypto.Uint256](tt),
+		dbft.WithRequestTxFrom[crypto.Uint256](func(v int, h ...crypto.Uint256) {
+			from = append(from, v)
+		}),
+		dbft.WithTxRetry[crypto.Uint256](10*time.Millisecond, 20*time.Millisecond),
+		dbft.WithOnTxFetched[crypto.Uint256](func(h crypto.Uint256, latency time.Duration, attempts int) {
+			results = append(results, fetched{h, latency, attempts})
+		}))...)
+	require.NoError(t, err)
+	service.Start(0)
+
+	tx := testTx(1)
+	service.OnReceive(s.getPrepareRequest(2, tx.Hash()))
+	req := service.PreparationPayloads[2]
+	service.OnReceive(s.getPrepareResponse(1, req.Hash(), 0))
+	require.Equal(t, []int{2}, from)
+
+	// Not yet.
+	tt.now = tt.now.Add(5 * time.Millisecond)
+	service.OnTxRetry()
+	require.Equal(t, []int{2}, from)
+
+	// Requested from the primary first and then from all known holders.
+	tt.now = tt.now.Add(5 * time.Millisecond)
+	service.OnTxRetry()
+	require.Equal(t, []int{2, 1}, from)
+
+	// Backoff is limited by MaxTxRetryInterval.
+	tt.now = tt.now.Add(19 * time.Millisecond)
+	service.OnTxRetry()
+	require.Equal(t, []int{2, 1}, from)
+	tt.now = tt.now.Add(time.Millisecond)
+	service.OnTxRetry()
+	require.Equal(t, []int{2, 1, 2}, from)
+
+	tt.now = tt.now.Add(time.Millisecond)
+	service.OnTransaction(tx)
+	require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+	require.Equal(t, []fetched{{tx.Hash(), 31 * time.Millisecond, 3}}, results)
+
+	// No more retries.
+	tt.now = tt.now.Add(time.Second)
+	service.OnTxRetry()
+	require.Len(t, from, 3)
+}
+
+func TestDBFT_ProposalLimits(t *testing.T) {
+	t.Run("invalid config", func(t *testing.T) {
+		s := newTestState(0, 4)
+		_, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithMaxProposalWeight[crypto.Uint256](10, nil))...)
+		require.Error(t, err)
+
+		_, err = dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithMaxProposalSize[crypto.Uint256](-1))...)
+		require.Error(t, err)
+	})
+
+	weight := func(tx dbft.Transaction[crypto.Uint256]) uint64 { return 2 * uint64(tx.(testTx)) }
+
+	t.Run("primary", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			opt      func(*dbft.Config[crypto.Uint256])
+			expected []testTx
+		}{
+			"count":  {dbft.WithMaxProposalTransactions[crypto.Uint256](3), []testTx{1, 2, 3}},
+			"size":   {dbft.WithMaxProposalSize[crypto.Uint256](8), []testTx{1, 2, 3}},
+			"weight": {dbft.WithMaxProposalWeight[crypto.Uint256](10, weight), []testTx{1, 2}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				s := newTestState(0, 1)
+				s.currHeight = 1
+				service, err := dbft.New[crypto.Uint256](append(s.getOptions(), tc.opt,
+					dbft.WithGetVerified[crypto.Uint256](func() []dbft.Transaction[crypto.Uint256] {
+						return []dbft.Transaction[crypto.Uint256]{testTx(1), testTx(2), testTx(3), testTx(4), testTx(5)}
+					}))...)
+				require.NoError(t, err)
+
+				service.Start(0)
+				p := s.tryRecv()
+				require.Equal(t, dbft.PrepareRequestType, p.Type())
+
+				hashes := make([]crypto.Uint256, 0, len(tc.expected))
+				for _, tx := range tc.expected {
+					hashes = append(hashes, tx.Hash())
+				}
+				require.Equal(t, hashes, p.GetPrepareRequest().TransactionHashes())
+			})
+		}
+	})
+
+	t.Run("backup", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			opt     func(*dbft.Config[crypto.Uint256])
+			missing testTx
+		}{
+			"count":  {opt: dbft.WithMaxProposalTransactions[crypto.Uint256](1)},
+			"size":   {opt: dbft.WithMaxProposalSize[crypto.Uint256](3)},
+			"weight": {opt: dbft.WithMaxProposalWeight[crypto.Uint256](10, weight), missing: 5},
+		} {
+			t.Run(name, func(t *testing.T) {
+				s := newTestState(2, 7)
+				s.currHeight = 4
+				s.pool.Add(testTx(1))
+				s.pool.Add(testTx(3))
+				// Example ChangeView implementation doesn't keep the reason.
+				var reason dbft.ChangeViewReason
+				service, err := dbft.New[crypto.Uint256](append(s.getOptions(), tc.opt,
+					dbft.WithNewChangeView[crypto.Uint256](func(v dbft.View, r dbft.ChangeViewReason, ts uint64) dbft.ChangeView {
+						reason = r
+						return consensus.NewChangeView(v, r, ts)
+					}))...)
+				require.NoError(t, err)
+				service.Start(0)
+
+				second := testTx(3)
+				if tc.missing != 0 {
+					second = tc.missing
+				}
+				service.OnReceive(s.getPrepareRequest(5, testTx(1).Hash(), second.Hash()))
+				if tc.missing != 0 {
+					require.Nil(t, s.tryRecv())
+					service.OnTransaction(tc.missing)
+				}
+
+				cv := s.tryRecv()
+				require.NotNil(t, cv)
+				require.Equal(t, dbft.ChangeViewType, cv.Type())
+				require.Equal(t, dbft.CVBlockRejectedByPolicy, reason)
+			})
+		}
+	})
+}
+
+func TestDBFT_VerifyTransaction(t *testing.T) {
+	verify := func(tx dbft.Transaction[crypto.Uint256]) error {
+		switch tx.(testTx) {
+		case 10:
+			return fmt.Errorf("%w: low fee", dbft.ErrTxRejectedByPolicy)
+		case 20:
+			return dbft.ErrTxInvalid
+		case 30:
+			return errors.New("unknown error")
+		}
+		return nil
+	}
+
+	for _, tc := range []struct {
+		tx       testTx
+		expected dbft.ChangeViewReason
+	}{
+		{10, dbft.CVTxRejectedByPolicy},
+		{20, dbft.CVTxInvalid},
+		{30, dbft.CVTxInvalid},
+	} {
+		for _, missing := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%d/missing=%t", tc.tx, missing), func(t *testing.T) {
+				s := newTestState(2, 7)
+				s.currHeight = 4
+				s.pool.Add(testTx(1))
+				if !missing {
+					s.pool.Add(tc.tx)
+				}
+
+				// Example ChangeView implementation doesn't keep the reason.
+				var (
+					reason   dbft.ChangeViewReason
+					rejected []crypto.Uint256
+				)
+				service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+					dbft.WithVerifyTransaction[crypto.Uint256](verify),
+					dbft.WithOnTxRejected[crypto.Uint256](func(h crypto.Uint256, err error) {
+						require.Error(t, err)
+						rejected = append(rejected, h)
+					}),
+					dbft.WithNewChangeView[crypto.Uint256](func(v dbft.View, r dbft.ChangeViewReason, ts uint64) dbft.ChangeView {
+						reason = r
+						return consensus.NewChangeView(v, r, ts)
+					}))...)
+				require.NoError(t, err)
+				service.Start(0)
+
+				service.OnReceive(s.getPrepareRequest(5, testTx(1).Hash(), tc.tx.Hash()))
+				if missing {
+					require.Nil(t, s.tryRecv())
+					service.OnTransaction(tc.tx)
+				}
+
+				cv := s.tryRecv()
+				require.NotNil(t, cv)
+				require.Equal(t, dbft.ChangeViewType, cv.Type())
+				require.Equal(t, tc.expected, reason)
+				require.Equal(t, []crypto.Uint256{tc.tx.Hash()}, rejected)
+				require.Nil(t, s.tryRecv())
+			})
+		}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		s := newTestState(2, 7)
+		s.currHeight = 4
+		s.pool.Add(testTx(1))
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithVerifyTransaction[crypto.Uint256](verify))...)
+		require.NoError(t, err)
+		service.Start(0)
+
+		service.OnReceive(s.getPrepareRequest(5, testTx(1).Hash(), testTx(2).Hash()))
+		require.Nil(t, s.tryRecv())
+		service.OnTransaction(testTx(2))
+		require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+	})
+}
+
+func TestDBFT_VerifyPayloads(t *testing.T) {
+	s := newTestState(2, 4)
+	s.currHeight = 4
+	errInvalid := errors.New("invalid")
+
+	// Replica 3 prepares the block proposed by the primary 1 and sends
+	// RecoveryMessage to replica 2.
+	r3 := s.copyWithIndex(3)
+	var rrValid = true
+	s3, err := dbft.New[crypto.Uint256](append(r3.getOptions(),
+		dbft.WithVerifyRecoveryRequest[crypto.Uint256](func(p Payload) error {
+			if !rrValid {
+				return errInvalid
+			}
+			return nil
+		}))...)
+	require.NoError(t, err)
+	s3.Start(0)
+	s3.OnReceive(s.getPrepareRequest(1))
+	require.Equal(t, dbft.PrepareResponseType, r3.tryRecv().Type())
+
+	t.Run("invalid RecoveryRequest", func(t *testing.T) {
+		rrValid = false
+		s3.OnReceive(s.getRecoveryRequest(2))
+		require.Nil(t, r3.tryRecv())
+		rrValid = true
+	})
+
+	s3.OnReceive(s.getRecoveryRequest(2))
+	rm := r3.tryRecv()
+	require.NotNil(t, rm)
+	require.Equal(t, dbft.RecoveryMessageType, rm.Type())
+
+	t.Run("invalid RecoveryMessage", func(t *testing.T) {
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithVerifyRecoveryMessage[crypto.Uint256](func(p Payload) error { return errInvalid }))...)
+		require.NoError(t, err)
+		service.Start(0)
+
+		service.OnReceive(rm)
+		require.Nil(t, s.tryRecv())
+		require.False(t, service.RequestSentOrReceived())
+	})
+
+	t.Run("invalid ChangeView", func(t *testing.T) {
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithVerifyChangeView[crypto.Uint256](func(p Payload) error {
+				if p.ValidatorIndex() == 0 {
+					return errInvalid
+				}
+				return nil
+			}))...)
+		require.NoError(t, err)
+		service.Start(0)
+
+		service.OnReceive(s.getChangeView(0, 1))
+		require.Nil(t, service.ChangeViewPayloads[0])
+		service.OnReceive(s.getChangeView(1, 1))
+		require.NotNil(t, service.ChangeViewPayloads[1])
+	})
+
+	t.Run("recovered payloads", func(t *testing.T) {
+		var (
+			service   *dbft.DBFT[crypto.Uint256]
+			recovered []Payload
+			sources   []Payload
+		)
+		record := func(p Payload) error {
+			// Original payload implementation is passed.
+			_, ok := p.(*consensus.Payload)
+			require.True(t, ok)
+			recovered = append(recovered, p)
+			sources = append(sources, service.RecoverySource())
+			return nil
+		}
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithVerifyPrepareRequest[crypto.Uint256](record),
+			dbft.WithVerifyPrepareResponse[crypto.Uint256](record))...)
+		require.NoError(t, err)
+		service.Start(0)
+
+		service.OnReceive(rm)
+		require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+		require.Len(t, recovered, 2)
+		for i, idx := range []uint16{1, 3} {
+			require.Equal(t, idx, recovered[i].ValidatorIndex())
+			require.Equal(t, rm, sources[i])
+			require.Equal(t, recovered[i], service.PreparationPayloads[idx])
+		}
+		require.Nil(t, service.RecoverySource())
+
+		// Payloads received directly have no recovery source.
+		service.OnReceive(s.getPrepareResponse(0, service.PreparationPayloads[1].Hash(), 0))
+		require.Len(t, recovered, 3)
+		require.Nil(t, sources[2])
+	})
+}
+
+func TestDBFT_DuplicatedTransactions(t *testing.T) {
+	s := newTestState(2, 7)
+	s.currHeight = 4
+	s.pool.Add(testTx(1))
+
+	// Example ChangeView implementation doesn't keep the reason.
+	var reason dbft.ChangeViewReason
+	service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+		dbft.WithNewChangeView[crypto.Uint256](func(v dbft.View, r dbft.ChangeViewReason, ts uint64) dbft.ChangeView {
+			reason = r
+			return consensus.NewChangeView(v, r, ts)
+		}))...)
+	require.NoError(t, err)
+	service.Start(0)
+
+	service.OnReceive(s.getPrepareRequest(5, testTx(1).Hash(), testTx(2).Hash(), testTx(1).Hash()))
+	cv := s.tryRecv()
+	require.NotNil(t, cv)
+	require.Equal(t, dbft.ChangeViewType, cv.Type())
+	require.Equal(t, dbft.CVBlockRejectedByPolicy, reason)
+	require.False(t, service.RequestSentOrReceived())
+
+	t.Run("primary", func(t *testing.T) {
+		s := newTestState(0, 1)
+		s.currHeight = 1
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithGetVerified[crypto.Uint256](func() []dbft.Transaction[crypto.Uint256] {
+				return []dbft.Transaction[crypto.Uint256]{testTx(1), testTx(2), testTx(1)}
+			}))...)
+		require.NoError(t, err)
+
+		service.Start(0)
+		p := s.tryRecv()
+		require.Equal(t, dbft.PrepareRequestType, p.Type())
+		require.Equal(t, []crypto.Uint256{testTx(1).Hash(), testTx(2).Hash()}, p.GetPrepareRequest().TransactionHashes())
+		require.NotNil(t, s.nextBlock())
+	})
+
+	t.Run("primary with limits", func(t *testing.T) {
+		s := newTestState(0, 1)
+		s.currHeight = 1
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithMaxProposalTransactions[crypto.Uint256](2),
+			dbft.WithGetVerified[crypto.Uint256](func() []dbft.Transaction[crypto.Uint256] {
+				return []dbft.Transaction[crypto.Uint256]{testTx(1), testTx(1), testTx(2)}
+			}))...)
+		require.NoError(t, err)
+
+		// Duplicates don't spend proposal limits.
+		service.Start(0)
+		p := s.tryRecv()
+		require.Equal(t, dbft.PrepareRequestType, p.Type())
+		require.Equal(t, []crypto.Uint256{testTx(1).Hash(), testTx(2).Hash()}, p.GetPrepareRequest().TransactionHashes())
+	})
+}
+
+func TestDBFT_AsyncVerification(t *testing.T) {
+	newService := func(t *testing.T, s *testState) (*dbft.DBFT[crypto.Uint256], *[]*dbft.VerificationTask[crypto.Uint256]) {
+		var tasks []*dbft.VerificationTask[crypto.Uint256]
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithVerifyAsync[crypto.Uint256](func(task *dbft.VerificationTask[crypto.Uint256]) {
+				tasks = append(tasks, task)
+			}))...)
+		require.NoError(t, err)
+		service.Start(0)
+		return service, &tasks
+	}
+	next := func(t *testing.T, tasks *[]*dbft.VerificationTask[crypto.Uint256]) *dbft.VerificationTask[crypto.Uint256] {
+		require.NotEmpty(t, *tasks)
+		task := (*tasks)[0]
+		*tasks = (*tasks)[1:]
+		return task
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		s := newTestState(2, 4)
+		s.currHeight = 4
+		service, tasks := newService(t, s)
+
+		p := s.getPrepareRequest(1)
+		service.OnReceive(p)
+		require.False(t, service.RequestSentOrReceived())
+
+		task := next(t, tasks)
+		require.Equal(t, p, task.Payload)
+		require.Nil(t, task.Recovery)
+		require.EqualValues(t, 5, task.Height)
+		service.OnVerificationResult(task, task.Verify())
+		require.True(t, service.RequestSentOrReceived())
+		require.Nil(t, s.tryRecv())
+
+		// Block verification task.
+		task = next(t, tasks)
+		require.Nil(t, task.Payload)
+		service.OnVerificationResult(task, task.Verify())
+		require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+
+		// Results are processed once.
+		service.OnVerificationResult(task, nil)
+		require.Nil(t, s.tryRecv())
+	})
+
+	t.Run("invalid payload", func(t *testing.T) {
+		s := newTestState(2, 4)
+		s.currHeight = 4
+		service, tasks := newService(t, s)
+
+		service.OnReceive(s.getPrepareRequest(1))
+		service.OnVerificationResult(next(t, tasks), errors.New("invalid"))
+		require.False(t, service.RequestSentOrReceived())
+		require.Empty(t, *tasks)
+	})
+
+	t.Run("invalid block", func(t *testing.T) {
+		s := newTestState(2, 4)
+		s.currHeight = 4
+		s.verify = func(dbft.Block[crypto.Uint256]) bool { return false }
+		service, tasks := newService(t, s)
+
+		service.OnReceive(s.getPrepareRequest(1))
+		task := next(t, tasks)
+		service.OnVerificationResult(task, task.Verify())
+		task = next(t, tasks)
+		service.OnVerificationResult(task, task.Verify())
+		require.Equal(t, dbft.ChangeViewType, s.tryRecv().Type())
+	})
+
+	t.Run("view changed", func(t *testing.T) {
+		s := newTestState(2, 4)
+		s.currHeight = 4
+		service, tasks := newService(t, s)
+
+		service.OnReceive(s.getPrepareRequest(1))
+		task := next(t, tasks)
+		service.OnVerificationResult(task, task.Verify())
+		block := next(t, tasks)
+
+		for _, i := range []uint16{0, 1, 3} {
+			service.OnReceive(s.getChangeView(i, 1))
+			task = next(t, tasks)
+			service.OnVerificationResult(task, task.Verify())
+		}
+		require.EqualValues(t, 1, service.ViewNumber)
+		s.ch = nil
+
+		service.OnVerificationResult(block, block.Verify())
+		require.Nil(t, s.tryRecv())
+	})
+
+	t.Run("height changed", func(t *testing.T) {
+		s := newTestState(2, 4)
+		s.currHeight = 4
+		service, tasks := newService(t, s)
+
+		service.OnReceive(s.getPrepareRequest(1))
+		task := next(t, tasks)
+
+		s.currHeight = 5
+		service.Reset(0)
+		service.OnVerificationResult(task, task.Verify())
+		require.False(t, service.RequestSentOrReceived())
+		require.Empty(t, *tasks)
+		require.Nil(t, s.tryRecv())
+	})
+}
+
+type (
+	// acceptingBlock accepts any signature.
+	acceptingBlock struct {
+		dbft.Block[crypto.Uint256]
+	}
+	// batchBlock records VerifyBatch calls.
+	batchBlock struct {
+		dbft.Block[crypto.Uint256]
+		batches *[]int
+	}
+)
+
+func (b acceptingBlock) Verify(dbft.PublicKey, []byte) error { return nil }
+
+func (b batchBlock) VerifyBatch(keys []dbft.PublicKey, signs [][]byte) []error {
+	*b.batches = append(*b.batches, len(keys))
+	errs := make([]error, len(keys))
+	for i := range keys {
+		errs[i] = b.Verify(keys[i], signs[i])
+	}
+	return errs
+}
+
+func TestDBFT_RecoveryVerification(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		t.Run(fmt.Sprintf("batch=%t", batch), func(t *testing.T) {
+			s := newTestState(4, 7)
+			s.currHeight = 4
+			s.pool.Add(testTx(1))
+
+			// Replica 3 doesn't check Commit signatures and sends
+			// RecoveryMessage with one invalid Commit.
+			r := s.copyWithIndex(3)
+			r.pool.Add(testTx(1))
+			rs, err := dbft.New[crypto.Uint256](append(r.getOptions(),
+				dbft.WithNewBlockFromContext[crypto.Uint256](func(ctx *dbft.Context[crypto.Uint256]) dbft.Block[crypto.Uint256] {
+					return acceptingBlock{newBlockFromContext(ctx)}
+				}))...)
+			require.NoError(t, err)
+			rs.Start(0)
+
+			req := s.getPrepareRequest(5, testTx(1).Hash())
+			rs.OnReceive(req)
+			for _, i := range []uint16{0, 1, 2} {
+				rs.OnReceive(s.getPrepareResponse(i, req.Hash(), 0))
+			}
+			header := rs.MakeHeader()
+			require.NotNil(t, header)
+			for _, i := range []uint16{0, 2} {
+				require.NoError(t, header.Sign(s.privs[i]))
+				rs.OnReceive(s.getCommit(i, header.Signature(), 0))
+			}
+			rs.OnReceive(s.getCommit(1, make([]byte, 64), 0))
+			require.Equal(t, 4, rs.CountCommitted())
+			r.ch = nil
+			rs.OnReceive(s.getRecoveryRequest(4))
+			rm := r.tryRecv()
+			require.Equal(t, dbft.RecoveryMessageType, rm.Type())
+
+			var batches []int
+			opts := append(s.getOptions(), dbft.WithRecoveryVerificationWorkers[crypto.Uint256](4))
+			if batch {
+				opts = append(opts, dbft.WithNewBlockFromContext[crypto.Uint256](func(ctx *dbft.Context[crypto.Uint256]) dbft.Block[crypto.Uint256] {
+					return batchBlock{newBlockFromContext(ctx), &batches}
+				}))
+			}
+			service, err := dbft.New[crypto.Uint256](opts...)
+			require.NoError(t, err)
+			service.Start(0)
+
+			service.OnReceive(rm)
+			require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+			require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+			for _, i := range []int{0, 2, 3, 4} {
+				require.NotNil(t, service.CommitPayloads[i])
+			}
+			require.Nil(t, service.CommitPayloads[1])
+			require.Nil(t, s.nextBlock())
+			if batch {
+				require.Equal(t, []int{4}, batches)
+			}
+
+			require.NoError(t, header.Sign(s.privs[6]))
+			service.OnReceive(s.getCommit(6, header.Signature(), 0))
+			require.NotNil(t, s.nextBlock())
+		})
+	}
+
+	t.Run("invalid config", func(t *testing.T) {
+		s := newTestState(4, 7)
+		_, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithRecoveryVerificationWorkers[crypto.Uint256](0))...)
+		require.Error(t, err)
+	})
+}
+
+func TestDBFT_Deduplication(t *testing.T) {
+	newService := func(t *testing.T, s *testState, opts ...func(*dbft.Config[crypto.Uint256])) (*dbft.DBFT[crypto.Uint256], *int) {
+		var verified int
+		count := func(Payload) error { verified++; return nil }
+		service, err := dbft.New[crypto.Uint256](append(append(s.getOptions(),
+			dbft.WithVerifyPrepareRequest[crypto.Uint256](count),
+			dbft.WithVerifyChangeView[crypto.Uint256](count)), opts...)...)
+		require.NoError(t, err)
+		service.Start(0)
+		return service, &verified
+	}
+
+	t.Run("duplicates", func(t *testing.T) {
+		s := newTestState(2, 7)
+		s.currHeight = 4
+		service, verified := newService(t, s)
+
+		req := s.getPrepareRequest(5)
+		service.OnReceive(req)
+		require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+		service.OnReceive(req)
+		service.OnReceive(req)
+		require.Nil(t, s.tryRecv())
+		require.Equal(t, 1, *verified)
+		require.EqualValues(t, 2, service.Duplicates(5))
+		require.EqualValues(t, 0, service.Duplicates(0))
+		require.EqualValues(t, 0, service.Duplicates(7))
+
+		// Counters are kept for the next height.
+		s.currHeight++
+		service.Reset(0)
+		require.EqualValues(t, 2, service.Duplicates(5))
+
+		// And follow validators when the list changes.
+		old := s.pubs
+		defer func() { s.pubs = old }()
+		_, newPub := crypto.Generate(rand.Reader)
+		s.pubs = slices.Clone(old)
+		s.pubs[4], s.pubs[5] = old[5], newPub
+		s.currHeight++
+		service.Reset(0)
+		require.EqualValues(t, 0, service.Duplicates(5))
+		require.EqualValues(t, 2, service.Duplicates(4))
+	})
+
+	t.Run("invalid payload", func(t *testing.T) {
+		s := newTestState(2, 7)
+		s.currHeight = 4
+		var valid bool
+		service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithVerifyChangeView[crypto.Uint256](func(Payload) error {
+				if !valid {
+					return errors.New("invalid")
+				}
+				return nil
+			}))...)
+		require.NoError(t, err)
+		service.Start(0)
+
+		cv := s.getChangeView(0, 1)
+		service.OnReceive(cv)
+		require.Nil(t, service.ChangeViewPayloads[0])
+		valid = true
+		service.OnReceive(cv)
+		require.NotNil(t, service.ChangeViewPayloads[0])
+		require.EqualValues(t, 0, service.Duplicates(0))
+	})
+
+	t.Run("LRU", func(t *testing.T) {
+		s := newTestState(2, 7)
+		s.currHeight = 4
+		service, verified := newService(t, s, dbft.WithMaxSeenPayloads[crypto.Uint256](2))
+
+		a, b, c := s.getChangeView(0, 1), s.getChangeView(1, 1), s.getChangeView(3, 1)
+		for _, p := range []Payload{a, b, a, c, b, c} {
+			service.OnReceive(p)
+		}
+		// The second b is processed since it's evicted by c (a is used
+		// more recently).
+		require.Equal(t, 4, *verified)
+		require.EqualValues(t, 1, service.Duplicates(0))
+		require.EqualValues(t, 0, service.Duplicates(1))
+		require.EqualValues(t, 1, service.Duplicates(3))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		s := newTestState(2, 7)
+		s.currHeight = 4
+		service, verified := newService(t, s, dbft.WithMaxSeenPayloads[crypto.Uint256](0))
+
+		cv := s.getChangeView(0, 1)
+		service.OnReceive(cv)
+		service.OnReceive(cv)
+		require.Equal(t, 2, *verified)
+		require.EqualValues(t, 0, service.Duplicates(0))
+	})
+}
+
+func TestDBFT_RateLimits(t *testing.T) {
+	type event struct {
+		validator int
+		typ       dbft.MessageType
+	}
+	var (
+		limit = dbft.RateLimit{Rate: 0.001, Burst: 2}
+		rr    = func(s *testState, from uint16, ts uint64) Payload {
+			return consensus.NewConsensusPayload(dbft.RecoveryRequestType, s.currHeight+1, from, 0, consensus.NewRecoveryRequest(ts*uint64(time.Second)))
+		}
+		newService = func(t *testing.T, s *testState, events *[]event, opts ...func(*dbft.Config[crypto.Uint256])) *dbft.DBFT[crypto.Uint256] {
+			service, err := dbft.New[crypto.Uint256](append(append(s.getOptions(),
+				dbft.WithOnRateLimited[crypto.Uint256](func(validator int, typ dbft.MessageType) {
+					*events = append(*events, event{validator, typ})
+				})), opts...)...)
+			require.NoError(t, err)
+			service.Start(0)
+			return service
+		}
+	)
+
+	t.Run("RecoveryRequest", func(t *testing.T) {
+		s := newTestState(2, 4)
+		s.currHeight = 4
+		var events []event
+		service := newService(t, s, &events, dbft.WithRecoveryRequestRateLimit[crypto.Uint256](limit))
+
+		for ts := range uint64(3) {
+			service.OnReceive(rr(s, 1, ts))
+		}
+		require.Equal(t, dbft.RecoveryMessageType, s.tryRecv().Type())
+		require.Equal(t, dbft.RecoveryMessageType, s.tryRecv().Type())
+		require.Nil(t, s.tryRecv())
+		require.Equal(t, []event{{1, dbft.RecoveryRequestType}}, events)
+
+		// Other validators are not affected.
+		service.OnReceive(rr(s, 0, 0))
+		require.Equal(t, dbft.RecoveryMessageType, s.tryRecv().Type())
+
+		// Requests that are not answered (we're not among the responders
+		// for validator 3) don't spend tokens.
+		for ts := range uint64(3) {
+			service.OnReceive(rr(s, 3, ts))
+		}
+		require.Nil(t, s.tryRecv())
+		require.Len(t, events, 1)
+
+		// Validator 1 is replaced, its successor has its own bucket.
+		old := s.pubs
+		defer func() { s.pubs = old }()
+		_, newPub := crypto.Generate(rand.Reader)
+		s.pubs = slices.Clone(old)
+		s.pubs[1] = newPub
+		s.currHeight++
+		service.Reset(0)
+		service.OnReceive(rr(s, 1, 10))
+		require.Equal(t, dbft.RecoveryMessageType, s.tryRecv().Type())
+		require.Len(t, events, 1)
+	})
+
+	t.Run("RecoveryMessage", func(t *testing.T) {
+		s := newTestState(2, 4)
+		s.currHeight = 4
+		var events []event
+		service := newService(t, s, &events, dbft.WithRecoveryResponseRateLimit[crypto.Uint256](limit))
+
+		for ts := range uint64(3) {
+			service.OnReceive(rr(s, 1, ts))
+		}
+		require.Equal(t, dbft.RecoveryMessageType, s.tryRecv().Type())
+		require.Equal(t, dbft.RecoveryMessageType, s.tryRecv().Type())
+		require.Nil(t, s.tryRecv())
+		require.Equal(t, []event{{1, dbft.RecoveryMessageType}}, events)
+	})
+
+	t.Run("ChangeView", func(t *testing.T) {
+		s := newTestState(2, 7)
+		s.currHeight = 4
+		var events []event
+		service := newService(t, s, &events, dbft.WithChangeViewRateLimit[crypto.Uint256](limit))
+
+		for v := range dbft.View(3) {
+			cv := consensus.NewChangeView(v+1, 0, uint64(v)*uint64(time.Second))
+			service.OnReceive(consensus.NewConsensusPayload(dbft.ChangeViewType, s.currHeight+1, 0, 0, cv))
+		}
+		require.EqualValues(t, 2, service.ChangeViewPayloads[0].GetChangeView().NewViewNumber())
+		require.Equal(t, []event{{0, dbft.ChangeViewType}}, events)
+
+		// Ignored payloads (older than the accepted one) don't spend tokens.
+		changeView := func(v dbft.View, ts uint64) {
+			cv := consensus.NewChangeView(v, 0, ts*uint64(time.Second))
+			service.OnReceive(consensus.NewConsensusPayload(dbft.ChangeViewType, s.currHeight+1, 1, 0, cv))
+		}
+		changeView(3, 0)
+		for ts := range uint64(3) {
+			changeView(2, ts+1)
+		}
+		require.EqualValues(t, 3, service.ChangeViewPayloads[1].GetChangeView().NewViewNumber())
+		changeView(4, 4)
+		require.EqualValues(t, 4, service.ChangeViewPayloads[1].GetChangeView().NewViewNumber())
+		require.Len(t, events, 1)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		s := newTestState(2, 4)
+		_, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+			dbft.WithChangeViewRateLimit[crypto.Uint256](dbft.RateLimit{Rate: -1, Burst: 1}))...)
+		require.Error(t, err)
+	})
+}
+
+func TestDBFT_SendTo(t *testing.T) {
+	s := newTestState(2, 4)
+	s.currHeight = 4
+	type sent struct {
+		to  int
+		typ dbft.MessageType
+	}
+	var unicast []sent
+	service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+		dbft.WithSendTo[crypto.Uint256](func(validator int, m Payload) {
+			require.EqualValues(t, 2, m.ValidatorIndex())
+			unicast = append(unicast, sent{validator, m.Type()})
+		}))...)
+	require.NoError(t, err)
+	service.Start(0)
+
+	service.OnReceive(s.getRecoveryRequest(1))
+	service.OnReceive(s.getChangeView(0, 0))
+	require.Equal(t, []sent{{1, dbft.RecoveryMessageType}, {0, dbft.RecoveryMessageType}}, unicast)
+	require.Nil(t, s.tryRecv())
+
+	// Other messages are broadcasted.
+	s.pool.Add(testTx(1))
+	service.OnReceive(s.getPrepareRequest(1, testTx(1).Hash()))
+	require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+	service.OnReceive(s.getPrepareResponse(0, service.PreparationPayloads[1].Hash(), 0))
+	require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+
+	// Commit is re-sent to everyone since it's not known who misses it.
+	service.OnTimeout(s.currHeight+1, 0)
+	require.Equal(t, dbft.RecoveryMessageType, s.tryRecv().Type())
+	require.Len(t, unicast, 2)
+}
+
+func TestDBFT_TargetedRecovery(t *testing.T) {
+	s := newTestState(3, 4)
+	s.currHeight = 4
+
+	// Replica 3 has preparations from 0, 1 and itself and its Commit.
+	s.pool.Add(testTx(1))
+	sendTo := dbft.WithSendTo[crypto.Uint256](func(_ int, p Payload) { s.ch = append(s.ch, p) })
+	service, err := dbft.New[crypto.Uint256](append(s.getOptions(), sendTo)...)
+	require.NoError(t, err)
+	service.Start(0)
+	req := s.getPrepareRequest(1, testTx(1).Hash())
+	service.OnReceive(req)
+	service.OnReceive(s.getPrepareResponse(0, req.Hash(), 0))
+	require.Equal(t, dbft.PrepareResponseType, s.tryRecv().Type())
+	require.Equal(t, dbft.CommitType, s.tryRecv().Type())
+
+	type contents struct {
+		request   bool
+		responses []uint16
+		commits   []uint16
+	}
+	recovered := func(rm Payload) contents {
+		var (
+			r = rm.GetRecoveryMessage()
+			c = contents{request: r.GetPrepareRequest(rm, s.pubs, 1) != nil}
+		)
+		for _, p := range r.GetPrepareResponses(rm, s.pubs) {
+			c.responses = append(c.responses, p.ValidatorIndex())
+		}
+		for _, p := range r.GetCommits(rm, s.pubs) {
+			c.commits = append(c.commits, p.ValidatorIndex())
+		}
+		return c
+	}
+	respond := func(from uint16, known *dbft.KnownPayloads) contents {
+		rr := consensus.NewRecoveryRequest(uint64(from+1) * uint64(time.Second))
+		rr.(dbft.TargetedRecoveryRequest).SetKnown(known)
+		service.OnReceive(consensus.NewConsensusPayload(dbft.RecoveryRequestType, s.currHeight+1, from, 0, rr))
+		rm := s.tryRecv()
+		require.Equal(t, dbft.RecoveryMessageType, rm.Type())
+		return recovered(rm)
+	}
+	known := func(preparations, commits []int) *dbft.KnownPayloads {
+		k := &dbft.KnownPayloads{
+			Preparations: dbft.NewBitmap(4),
+			PreCommits:   dbft.NewBitmap(4),
+			Commits:      dbft.NewBitmap(4),
+			ChangeViews:  dbft.NewBitmap(4),
+		}
+		for _, i := range preparations {
+			k.Preparations.Set(i)
+		}
+		for _, i := range commits {
+			k.Commits.Set(i)
+		}
+		return k
+	}
+	full := contents{request: true, responses: []uint16{0, 3}, commits: []uint16{3}}
+
+	require.Equal(t, full, respond(0, nil))
+	require.Equal(t, contents{}, respond(1, known([]int{0, 1, 3}, []int{3})))
+	require.Equal(t, contents{commits: []uint16{3}}, respond(2, known([]int{0, 1, 3}, nil)))
+	// PrepareRequest is always sent along with responses.
+	require.Equal(t, contents{request: true, responses: []uint16{3}, commits: []uint16{3}},
+		respond(0, known([]int{0, 1}, nil)))
+
+	t.Run("request", func(t *testing.T) {
+		r := newTestState(2, 4)
+		r.currHeight = 4
+		r.pool.Add(testTx(1))
+		requester, err := dbft.New[crypto.Uint256](r.getOptions()...)
+		require.NoError(t, err)
+		requester.Start(0)
+		requester.OnReceive(req)
+		require.Equal(t, dbft.PrepareResponseType, r.tryRecv().Type())
+
+		requester.OnTimeout(5, 0)
+		rr := r.tryRecv()
+		require.Equal(t, dbft.RecoveryRequestType, rr.Type())
+		require.Equal(t, known([]int{1, 2}, nil), rr.GetRecoveryRequest().(dbft.TargetedRecoveryRequest).Known())
+
+		service.OnReceive(rr)
+		rm := s.tryRecv()
+		require.Equal(t, dbft.RecoveryMessageType, rm.Type())
+		require.Equal(t, full, recovered(rm))
+	})
+
+	t.Run("broadcast", func(t *testing.T) {
+		// Known payloads are not skipped if the reply is broadcasted.
+		b := newTestState(3, 4)
+		b.currHeight = 4
+		b.pool.Add(testTx(1))
+		service, err := dbft.New[crypto.Uint256](b.getOptions()...)
+		require.NoError(t, err)
+		service.Start(0)
+		service.OnReceive(req)
+		service.OnReceive(b.getPrepareResponse(0, req.Hash(), 0))
+		require.Equal(t, dbft.PrepareResponseType, b.tryRecv().Type())
+		require.Equal(t, dbft.CommitType, b.tryRecv().Type())
+
+		rr := consensus.NewRecoveryRequest(uint64(time.Second))
+		rr.(dbft.TargetedRecoveryRequest).SetKnown(known([]int{0, 1, 3}, []int{3}))
+		service.OnReceive(consensus.NewConsensusPayload(dbft.RecoveryRequestType, b.currHeight+1, 1, 0, rr))
+		rm := b.tryRecv()
+		require.Equal(t, dbft.RecoveryMessageType, rm.Type())
+		require.Equal(t, full, recovered(rm))
+	})
+
+	t.Run("change views", func(t *testing.T) {
+		newService := func(s *testState) *dbft.DBFT[crypto.Uint256] {
+			service, err := dbft.New[crypto.Uint256](append(s.getOptions(),
+				dbft.WithSendTo[crypto.Uint256](func(_ int, p Payload) { s.ch = append(s.ch, p) }))...)
+			require.NoError(t, err)
+			service.Start(0)
+			return service
+		}
+		changeViews := func(s *testState, service *dbft.DBFT[crypto.Uint256], from ...uint16) {
+			for _, i := range from {
+				service.OnReceive(s.getChangeView(i, 1))
+			}
+			require.EqualValues(t, 1, service.ViewNumber)
+			for s.tryRecv() != nil {
+			}
+		}
+
+		// Requester has moved to view 1 with ChangeViews from 0, 1 and 3.
+		r := newTestState(2, 4)
+		r.currHeight = 4
+		requester := newService(r)
+		changeViews(r, requester, 0, 1, 3)
+		requester.OnTimeout(5, 1)
+		rr := r.tryRecv()
+		require.Equal(t, dbft.RecoveryRequestType, rr.Type())
+		k := rr.GetRecoveryRequest().(dbft.TargetedRecoveryRequest).Known()
+		for i := range 4 {
+			require.Equal(t, i != 2, k.ChangeViews.IsSet(i), i)
+		}
+
+		// Responder has the same ChangeViews, so none is sent.
+		p := newTestState(3, 4)
+		p.currHeight = 4
+		responder := newService(p)
+		changeViews(p, responder, 0, 1, 2)
+		responder.OnReceive(rr)
+		rm := p.tryRecv()
+		require.Equal(t, dbft.RecoveryMessageType, rm.Type())
+		var got []uint16
+		for _, cv := range rm.GetRecoveryMessage().GetChangeViews(rm, p.pubs) {
+			got = append(got, cv.ValidatorIndex())
+		}
+		require.Equal(t, []uint16{2}, got)
+	})
+}
+
+func BenchmarkDBFT_OnTransaction(b *testing.B) {
+	for _, n := range []int{10_000, 50_000} {
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			s := newTestState(2, 7)
+			s.currHeight = 4
+
+			txs := make([]testTx, n)
+			hashes := make([]crypto.Uint256, n)
+			for i := range txs {
+				txs[i] = testTx(i + 1)
+				hashes[i] = txs[i].Hash()
+			}
+			req := s.getPrepareRequest(5, hashes...)
+
+			for b.Loop() {
+				b.StopTimer()
+				s.ch = s.ch[:0]
+				service, err := dbft.New[crypto.Uint256](s.getOptions()...)
+				require.NoError(b, err)
+				service.Start(0)
+				b.StartTimer()
+
+				service.OnReceive(req)
+				for _, tx := range txs {
+					service.OnTransaction(tx)
+				}
+				require.Equal(b, dbft.PrepareResponseType, s.tryRecv().Type())
+			}
+		})
+	}
+}
+
+func BenchmarkDBFT_Round(b *testing.B) {
+	for _, n := range []int{7, 21, 100, 500} {
+		b.Run(fmt.Sprintf("N=%d", n), func(b *testing.B) {
+			// Backup 1 collects preparations and commits from all other
+			// nodes, primary is 0.
+			s := newTestState(1, n)
+			s.currHeight = uint32(n - 1)
+			s.pool.Add(testTx(1))
+
+			service, err := dbft.New[crypto.Uint256](s.getOptions()...)
+			require.NoError(b, err)
+			service.Start(0)
+			req := s.getPrepareRequest(0, testTx(1).Hash())
+			service.OnReceive(req)
+			header := service.MakeHeader()
+			require.NotNil(b, header)
+
+			var resps, commits []Payload
+			for i := range n {
+				if i != 0 && i != 1 {
+					resps = append(resps, s.getPrepareResponse(uint16(i), req.Hash(), 0))
+				}
+				if i != 1 {
+					require.NoError(b, header.Sign(s.privs[i]))
+					commits = append(commits, s.getCommit(uint16(i), header.Signature(), 0))
+				}
+			}
+
+			for b.Loop() {
+				b.StopTimer()
+				s.ch = s.ch[:0]
+				s.blocks = s.blocks[:0]
+				service, _ := dbft.New[crypto.Uint256](s.getOptions()...)
+				service.Start(0)
+				b.StartTimer()
+
+				service.OnReceive(req)
+				for _, p := range resps {
+					service.OnReceive(p)
+				}
+				for _, p := range commits {
+					service.OnReceive(p)
+				}
+				require.Len(b, s.blocks, 1)
+			}
+		})
+	}
+}
+
+func (s testState) getChangeView(from uint16, view dbft.View) Payload {
 	cv := consensus.NewChangeView(view, 0, 0)
 
 	p := consensus.NewConsensusPayload(dbft.ChangeViewType, s.currHeight+1, from, 0, cv)
@@ -1035,7 +3427,7 @@ func (s testState) getRecoveryRequest(from uint16) Payload {
 	return p
 }
 
-func (s testState) getCommit(from uint16, sign []byte, view byte) Payload {
+func (s testState) getCommit(from uint16, sign []byte, view dbft.View) Payload {
 	c := consensus.NewCommit(sign)
 	p := consensus.NewConsensusPayload(dbft.CommitType, s.currHeight+1, from, view, c)
 	return p
@@ -1047,19 +3439,35 @@ func (s testState) getAMEVCommit(from uint16, sign []byte) Payload {
 	return p
 }
 
-func (s testState) getPreCommit(from uint16, data []byte, view byte) Payload {
+func (s testState) getPreCommit(from uint16, data []byte, view dbft.View) Payload {
 	c := consensus.NewPreCommit(data)
 	p := consensus.NewConsensusPayload(dbft.PreCommitType, s.currHeight+1, from, view, c)
 	return p
 }
 
-func (s testState) getPrepareResponse(from uint16, phash crypto.Uint256, view byte) Payload {
+func (s testState) getPrepareResponse(from uint16, phash crypto.Uint256, view dbft.View) Payload {
 	resp := consensus.NewPrepareResponse(phash)
 
 	p := consensus.NewConsensusPayload(dbft.PrepareResponseType, s.currHeight+1, from, view, resp)
 	return p
 }
 
+// getSignedPrepareResponse returns PrepareResponse with preparation signature
+// of the header required for fast path.
+func (s testState) getSignedPrepareResponse(t *testing.T, from uint16, phash crypto.Uint256, header dbft.Block[crypto.Uint256]) Payload {
+	p := s.getPrepareResponse(from, phash, 0)
+	p.GetPrepareResponse().(dbft.SignedPreparation).SetSignature(s.getPreparationSignature(t, from, header))
+	return p
+}
+
+// getPreparationSignature returns preparation signature of the header made by
+// the given validator.
+func (s testState) getPreparationSignature(t *testing.T, from uint16, header dbft.Block[crypto.Uint256]) []byte {
+	sig, err := header.(dbft.PreparationSigner).SignPreparation(s.privs[from])
+	require.NoError(t, err)
+	return sig
+}
+
 func (s testState) getPrepareRequest(from uint16, hashes ...crypto.Uint256) Payload {
 	return s.getPrepareRequestWithHeight(from, s.currHeight+1, hashes...)
 }
@@ -1249,6 +3657,10 @@ func (tx testTx) Hash() (h crypto.Uint256) {
 	return
 }
 
+func (tx testTx) Size() int {
+	return int(tx)
+}
+
 func newTestPool() *testPool {
 	return &testPool{
 		storage: make(map[crypto.Uint256]testTx),
@@ -0,0 +1,573 @@
+/*
+Package dkg implements distributed key generation protocol producing threshold
+key material for anti-MEV dBFT extension. It implements Pedersen's
+joint-Feldman DKG: every validator deals a random secret with Feldman
+verifiable secret sharing, invalid or missing deals are handled via the
+complaint/justification round and the resulting key is the sum of all qualified
+secrets. Nobody (including dealers) learns the resulting private key, every
+validator gets a share of it instead.
+
+The protocol is run among the same validator list as the one returned by
+[dbft.Config.GetValidators], validator indexes are reused as participant
+identifiers. Network interaction is hidden behind Broadcast-like callbacks,
+it's the caller's duty to deliver messages and to call [Session] methods in
+the proper order:
+
+ 1. [Session.Deal] starts the protocol.
+ 2. [Session.OnMessage] is called for every received message.
+ 3. [Session.Complain] is called once the dealing phase is over (all deals are
+    received or some timeout has passed).
+ 4. [Session.Agree] is called once the complaint phase is over, it fixes the
+    set of qualified dealers (QUAL) and announces it to other participants.
+ 5. [Session.Finalize] is called once the agreement phase is over and returns
+    the resulting [KeyShare].
+
+Participants only get the same key if they get the same QUAL, so the protocol
+relies on consistent broadcast: every broadcasted message must be delivered
+to all honest participants before they move to the next phase (e.g. messages
+are included into blocks and phases are bounded by block heights). Since it
+can't be checked locally, participants additionally exchange their QUAL
+digests and [Session.Finalize] fails with [ErrNoAgreement] unless a quorum of
+participants (the same as dBFT M) has the same one. Any two quorums share an
+honest participant, so all successful participants get the same key even if
+the broadcast is not consistent, it only affects liveness then.
+
+[KeyShare] is used by the threshold PreBlock reference implementation from
+internal/consensus: every validator publishes its decryption share as
+PreCommit data and the final block is built from the combined secret.
+*/
+package dkg
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"slices"
+
+	"github.com/nspcc-dev/dbft"
+)
+
+// MessageType is a type of DKG message.
+type MessageType byte
+
+// These constants enumerate all DKG message types.
+const (
+	// DealType is a broadcasted message with dealer's polynomial commitments.
+	DealType MessageType = iota
+	// ShareType is a private message with a secret share for the recipient.
+	ShareType
+	// ComplaintType is a broadcasted message accusing dealer of invalid or
+	// missing share.
+	ComplaintType
+	// JustificationType is a broadcasted dealer's answer to the complaint
+	// revealing disputed share along with its commitments.
+	JustificationType
+	// QualType is a broadcasted digest of qualified dealers set.
+	QualType
+)
+
+// String implements fmt.Stringer interface.
+func (t MessageType) String() string {
+	switch t {
+	case DealType:
+		return "Deal"
+	case ShareType:
+		return "Share"
+	case ComplaintType:
+		return "Complaint"
+	case JustificationType:
+		return "Justification"
+	case QualType:
+		return "Qual"
+	default:
+		return fmt.Sprintf("UNKNOWN(%02x)", byte(t))
+	}
+}
+
+// Message is a DKG protocol message. Its authenticity (that it's really sent by
+// From validator) must be ensured by the transport.
+type Message struct {
+	// Type is the message type.
+	Type MessageType
+	// From is an index of the sender.
+	From uint16
+	// Target is an index of share recipient for Share, index of accused
+	// dealer for Complaint and index of complainant for Justification.
+	// It's not used for Deal and Qual.
+	Target uint16
+	// Commitments are dealer's polynomial commitments (Deal and
+	// Justification only).
+	Commitments [][]byte
+	// Share is a secret share (Share and Justification only).
+	Share []byte
+	// Digest is a digest of qualified dealers and their commitments (Qual
+	// only).
+	Digest []byte
+}
+
+// Config contains DKG session parameters.
+type Config struct {
+	// Validators is the list of participants, usually obtained from
+	// [dbft.Config.GetValidators].
+	Validators []dbft.PublicKey
+	// MyIndex is an index of the current node in Validators.
+	MyIndex int
+	// Threshold is the number of shares needed to use the key. By default
+	// it's equal to the number of validators needed for consensus (M).
+	Threshold int
+	// Broadcast should deliver the message to all other participants. It
+	// must be a consistent broadcast (see package documentation).
+	Broadcast func(m *Message)
+	// Send should deliver the message to the specified participant only.
+	// The channel must be confidential since it's used to transfer secret
+	// shares.
+	Send func(to uint16, m *Message)
+	// Rand is a source of entropy, crypto/rand is used by default.
+	Rand io.Reader
+}
+
+// phase is a DKG session phase.
+type phase byte
+
+const (
+	phaseInit phase = iota
+	phaseDealing
+	phaseComplaining
+	phaseAgreeing
+	phaseFinished
+)
+
+// dealerState is everything a participant knows about a single dealer.
+type dealerState struct {
+	commitments []*big.Int
+	// share is the share received from this dealer, nil if no (valid) share
+	// is received yet.
+	share *big.Int
+	// rawShare is the share received from this dealer before its commitments.
+	rawShare *big.Int
+	// complaints are indexes of participants complained about this dealer.
+	complaints map[uint16]bool
+	// disqualified is set once the dealer is proven to be dishonest.
+	disqualified bool
+	// digest is the QUAL digest announced by this participant, nil if
+	// nothing is received yet.
+	digest []byte
+}
+
+// Session is a single DKG protocol run. It's not thread-safe.
+type Session struct {
+	cfg   Config
+	n     int
+	phase phase
+
+	poly    polynomial
+	dealers []dealerState
+	// qual is the set of qualified dealers fixed by Agree.
+	qual []int
+}
+
+var (
+	// ErrWrongPhase is returned when the method is called out of order.
+	ErrWrongPhase = errors.New("wrong DKG phase")
+	// ErrNotEnoughDealers is returned from [Session.Agree] if too few
+	// dealers qualified to produce a secure key.
+	ErrNotEnoughDealers = errors.New("not enough qualified dealers")
+	// ErrNoAgreement is returned from [Session.Finalize] if not enough
+	// participants announced the same set of qualified dealers.
+	ErrNoAgreement = errors.New("no agreement on qualified dealers")
+)
+
+// New returns new DKG session for the given configuration.
+func New(cfg Config) (*Session, error) {
+	n := len(cfg.Validators)
+	if n == 0 {
+		return nil, errors.New("empty validators list")
+	}
+	if cfg.MyIndex < 0 || cfg.MyIndex >= n {
+		return nil, fmt.Errorf("invalid index %d for %d validators", cfg.MyIndex, n)
+	}
+	if cfg.Threshold == 0 {
+		cfg.Threshold = n - (n-1)/3
+	}
+	if cfg.Threshold < 1 || cfg.Threshold > n {
+		return nil, fmt.Errorf("invalid threshold %d for %d validators", cfg.Threshold, n)
+	}
+	if cfg.Broadcast == nil {
+		return nil, errors.New("Broadcast is nil")
+	}
+	if cfg.Send == nil {
+		return nil, errors.New("Send is nil")
+	}
+	if cfg.Rand == nil {
+		cfg.Rand = rand.Reader
+	}
+
+	s := &Session{
+		cfg:     cfg,
+		n:       n,
+		dealers: make([]dealerState, n),
+	}
+	for i := range s.dealers {
+		s.dealers[i].complaints = make(map[uint16]bool)
+	}
+	return s, nil
+}
+
+// Deal generates a random polynomial of Threshold-1 degree, broadcasts its
+// commitments and sends shares to all other participants.
+func (s *Session) Deal() error {
+	if s.phase != phaseInit {
+		return ErrWrongPhase
+	}
+
+	poly, err := randPolynomial(s.cfg.Rand, s.cfg.Threshold-1)
+	if err != nil {
+		return fmt.Errorf("can't generate polynomial: %w", err)
+	}
+	s.poly = poly
+	s.phase = phaseDealing
+
+	comms := poly.commit()
+	me := &s.dealers[s.cfg.MyIndex]
+	me.commitments = comms
+	me.share = poly.eval(int64(s.cfg.MyIndex) + 1)
+
+	s.cfg.Broadcast(&Message{
+		Type:        DealType,
+		From:        uint16(s.cfg.MyIndex),
+		Commitments: encodeInts(comms),
+	})
+	for i := range s.n {
+		if i == s.cfg.MyIndex {
+			continue
+		}
+		s.cfg.Send(uint16(i), &Message{
+			Type:   ShareType,
+			From:   uint16(s.cfg.MyIndex),
+			Target: uint16(i),
+			Share:  poly.eval(int64(i) + 1).Bytes(),
+		})
+	}
+	return nil
+}
+
+// OnMessage processes a message from another participant. Messages can be
+// received in any order within the phase (deals and shares are also accepted
+// before [Session.Deal] call), however messages from the previous phases are
+// not accepted once the next phase starts. An error is
+// returned for invalid messages, but the session state remains consistent.
+func (s *Session) OnMessage(m *Message) error {
+	if int(m.From) >= s.n || int(m.From) == s.cfg.MyIndex {
+		return fmt.Errorf("unexpected sender %d", m.From)
+	}
+	if s.phase == phaseFinished {
+		return ErrWrongPhase
+	}
+
+	switch m.Type {
+	case DealType:
+		return s.onDeal(m)
+	case ShareType:
+		return s.onShare(m)
+	case ComplaintType:
+		return s.onComplaint(m)
+	case JustificationType:
+		return s.onJustification(m)
+	case QualType:
+		return s.onQual(m)
+	default:
+		return fmt.Errorf("unknown message type %s", m.Type)
+	}
+}
+
+func (s *Session) onDeal(m *Message) error {
+	if s.phase > phaseDealing {
+		return ErrWrongPhase
+	}
+	d := &s.dealers[m.From]
+	if d.commitments != nil {
+		return fmt.Errorf("duplicate deal from %d", m.From)
+	}
+	comms, err := s.decodeCommitments(m)
+	if err != nil {
+		return err
+	}
+	d.commitments = comms
+	if d.rawShare != nil {
+		sh := d.rawShare
+		d.rawShare = nil
+		return s.acceptShare(m.From, sh)
+	}
+	return nil
+}
+
+func (s *Session) onShare(m *Message) error {
+	if s.phase > phaseDealing {
+		return ErrWrongPhase
+	}
+	if int(m.Target) != s.cfg.MyIndex {
+		return fmt.Errorf("share from %d is addressed to %d", m.From, m.Target)
+	}
+	d := &s.dealers[m.From]
+	if d.share != nil || d.rawShare != nil {
+		return fmt.Errorf("duplicate share from %d", m.From)
+	}
+	sh, err := decodeScalar(m.Share)
+	if err != nil {
+		return fmt.Errorf("invalid share from %d: %w", m.From, err)
+	}
+	if d.commitments == nil {
+		d.rawShare = sh
+		return nil
+	}
+	return s.acceptShare(m.From, sh)
+}
+
+// acceptShare checks the share against dealer's commitments and keeps it if
+// it's valid.
+func (s *Session) acceptShare(from uint16, sh *big.Int) error {
+	if !s.verifyShare(from, uint16(s.cfg.MyIndex), sh) {
+		return fmt.Errorf("share from %d doesn't match commitments", from)
+	}
+	s.dealers[from].share = sh
+	return nil
+}
+
+// verifyShare checks that sh is a valid share of dealer for participant to.
+func (s *Session) verifyShare(dealer uint16, to uint16, sh *big.Int) bool {
+	comms := s.dealers[dealer].commitments
+	if comms == nil {
+		return false
+	}
+	return expG(sh).Cmp(evalCommitments(comms, int64(to)+1)) == 0
+}
+
+// decodeCommitments returns valid commitments from Deal or Justification m.
+func (s *Session) decodeCommitments(m *Message) ([]*big.Int, error) {
+	if len(m.Commitments) != s.cfg.Threshold {
+		return nil, fmt.Errorf("invalid number of commitments from %d: %d", m.From, len(m.Commitments))
+	}
+	comms, err := decodeElements(m.Commitments)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commitments from %d: %w", m.From, err)
+	}
+	return comms, nil
+}
+
+// Complain finishes dealing phase. Every dealer that hasn't sent its
+// commitments or a valid share to this node is accused via a broadcasted
+// complaint, so all participants exclude it unless it's justified.
+func (s *Session) Complain() error {
+	if s.phase != phaseDealing {
+		return ErrWrongPhase
+	}
+	s.phase = phaseComplaining
+
+	for i := range s.dealers {
+		d := &s.dealers[i]
+		if i == s.cfg.MyIndex || d.share != nil {
+			continue
+		}
+		d.rawShare = nil
+		d.complaints[uint16(s.cfg.MyIndex)] = true
+		s.cfg.Broadcast(&Message{
+			Type:   ComplaintType,
+			From:   uint16(s.cfg.MyIndex),
+			Target: uint16(i),
+		})
+	}
+	return nil
+}
+
+func (s *Session) onComplaint(m *Message) error {
+	if s.phase > phaseComplaining {
+		return ErrWrongPhase
+	}
+	if int(m.Target) >= s.n {
+		return fmt.Errorf("complaint from %d against unknown dealer %d", m.From, m.Target)
+	}
+	if int(m.Target) == s.cfg.MyIndex {
+		// Our own deal is valid, so just reveal the share and repeat
+		// commitments for those who missed them.
+		if s.poly != nil {
+			s.cfg.Broadcast(&Message{
+				Type:        JustificationType,
+				From:        uint16(s.cfg.MyIndex),
+				Target:      m.From,
+				Commitments: encodeInts(s.dealers[s.cfg.MyIndex].commitments),
+				Share:       s.poly.eval(int64(m.From) + 1).Bytes(),
+			})
+		}
+		return nil
+	}
+	s.dealers[m.Target].complaints[m.From] = true
+	return nil
+}
+
+func (s *Session) onJustification(m *Message) error {
+	if s.phase > phaseComplaining {
+		return ErrWrongPhase
+	}
+	if int(m.Target) >= s.n {
+		return fmt.Errorf("justification from %d for unknown participant %d", m.From, m.Target)
+	}
+	d := &s.dealers[m.From]
+	if !d.complaints[m.Target] {
+		return fmt.Errorf("unsolicited justification from %d", m.From)
+	}
+	delete(d.complaints, m.Target)
+
+	// Commitments must be the same for everyone, participants that have
+	// missed the deal take them from here.
+	comms, err := s.decodeCommitments(m)
+	if err != nil || d.commitments != nil && !slices.EqualFunc(d.commitments, comms, func(a, b *big.Int) bool { return a.Cmp(b) == 0 }) {
+		d.disqualified = true
+		return fmt.Errorf("invalid justification commitments from %d", m.From)
+	}
+	d.commitments = comms
+
+	sh, err := decodeScalar(m.Share)
+	if err != nil || !s.verifyShare(m.From, m.Target, sh) {
+		d.disqualified = true
+		return fmt.Errorf("invalid justification from %d", m.From)
+	}
+	if int(m.Target) == s.cfg.MyIndex {
+		d.share = sh
+	}
+	return nil
+}
+
+func (s *Session) onQual(m *Message) error {
+	d := &s.dealers[m.From]
+	if d.digest != nil {
+		return fmt.Errorf("duplicate qual from %d", m.From)
+	}
+	if len(m.Digest) != sha256.Size {
+		return fmt.Errorf("invalid qual digest from %d", m.From)
+	}
+	d.digest = m.Digest
+	return nil
+}
+
+// Agree finishes complaint phase. Dealers with unanswered complaints are
+// excluded, the set of qualified dealers is fixed and its digest is
+// broadcasted, so that participants can check they have the same one.
+func (s *Session) Agree() error {
+	if s.phase != phaseComplaining {
+		return ErrWrongPhase
+	}
+	s.phase = phaseAgreeing
+
+	var qual []int
+	for i := range s.dealers {
+		d := &s.dealers[i]
+		if d.commitments == nil || d.disqualified || len(d.complaints) != 0 {
+			continue
+		}
+		if d.share == nil {
+			// Can only happen if our complaint is answered with someone
+			// else's share which is not possible for a proper transport.
+			s.phase = phaseFinished
+			return fmt.Errorf("no valid share from qualified dealer %d", i)
+		}
+		qual = append(qual, i)
+	}
+	// Up to n-Threshold participants may be faulty (it's F for the default
+	// threshold), so at least one qualified dealer is honest if there are
+	// more of them. The key is random and unknown to any coalition of faulty
+	// participants only if it includes a secret of some honest dealer.
+	if len(qual) < s.n-s.cfg.Threshold+1 {
+		s.phase = phaseFinished
+		return fmt.Errorf("%w: %d", ErrNotEnoughDealers, len(qual))
+	}
+	s.qual = qual
+
+	digest := s.qualDigest()
+	s.dealers[s.cfg.MyIndex].digest = digest
+	s.cfg.Broadcast(&Message{
+		Type:   QualType,
+		From:   uint16(s.cfg.MyIndex),
+		Digest: digest,
+	})
+	return nil
+}
+
+// qualDigest returns a digest of qualified dealers and their commitments.
+func (s *Session) qualDigest() []byte {
+	h := sha256.New()
+	for _, i := range s.qual {
+		_ = binary.Write(h, binary.BigEndian, uint16(i))
+		for _, c := range s.dealers[i].commitments {
+			b := c.Bytes()
+			_ = binary.Write(h, binary.BigEndian, uint16(len(b)))
+			h.Write(b)
+		}
+	}
+	return h.Sum(nil)
+}
+
+// Finalize finishes the protocol. It checks that a quorum of participants
+// has announced the same set of qualified dealers and computes the resulting
+// key share from their deals.
+func (s *Session) Finalize() (*KeyShare, error) {
+	if s.phase != phaseAgreeing {
+		return nil, ErrWrongPhase
+	}
+	s.phase = phaseFinished
+
+	var (
+		digest = s.dealers[s.cfg.MyIndex].digest
+		agreed int
+	)
+	for i := range s.dealers {
+		if bytes.Equal(s.dealers[i].digest, digest) {
+			agreed++
+		}
+	}
+	if quorum := s.n - (s.n-1)/3; agreed < quorum {
+		return nil, fmt.Errorf("%w: %d of %d", ErrNoAgreement, agreed, quorum)
+	}
+
+	var (
+		secret = new(big.Int)
+		comms  = make([][]*big.Int, 0, len(s.qual))
+	)
+	for _, i := range s.qual {
+		secret.Add(secret, s.dealers[i].share)
+		comms = append(comms, s.dealers[i].commitments)
+	}
+
+	return newKeyShare(s.cfg.MyIndex, s.n, s.cfg.Threshold, s.qual, secret.Mod(secret, q), comms), nil
+}
+
+func encodeInts(xs []*big.Int) [][]byte {
+	res := make([][]byte, len(xs))
+	for i := range xs {
+		res[i] = xs[i].Bytes()
+	}
+	return res
+}
+
+func decodeElements(bs [][]byte) ([]*big.Int, error) {
+	res := make([]*big.Int, len(bs))
+	for i := range bs {
+		res[i] = new(big.Int).SetBytes(bs[i])
+		if !isGroupElement(res[i]) {
+			return nil, fmt.Errorf("element %d is not in group", i)
+		}
+	}
+	return res, nil
+}
+
+func decodeScalar(b []byte) (*big.Int, error) {
+	k := new(big.Int).SetBytes(b)
+	if k.Cmp(q) >= 0 {
+		return nil, errors.New("scalar is out of range")
+	}
+	return k, nil
+}
@@ -0,0 +1,396 @@
+package dkg
+
+import (
+	"crypto/rand"
+	"math/big"
+	"slices"
+	"testing"
+
+	"github.com/nspcc-dev/dbft"
+	"github.com/nspcc-dev/dbft/internal/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	// memNetwork is an in-memory DKG transport delivering messages in order.
+	memNetwork struct {
+		nodes []*Session
+		queue []envelope
+		// drop allows to filter out messages before delivery.
+		drop func(to int, m *Message) bool
+		// faulty nodes are allowed to fail finalization.
+		faulty map[int]bool
+	}
+
+	envelope struct {
+		to int
+		m  *Message
+	}
+)
+
+func newMemNetwork(t *testing.T, n int, threshold int) *memNetwork {
+	var (
+		net  = &memNetwork{nodes: make([]*Session, n)}
+		pubs = make([]dbft.PublicKey, n)
+	)
+	for i := range pubs {
+		_, pubs[i] = crypto.Generate(rand.Reader)
+	}
+	for i := range net.nodes {
+		s, err := New(Config{
+			Validators: pubs,
+			MyIndex:    i,
+			Threshold:  threshold,
+			Broadcast: func(m *Message) {
+				for j := range n {
+					if j != i {
+						net.queue = append(net.queue, envelope{to: j, m: m})
+					}
+				}
+			},
+			Send: func(to uint16, m *Message) {
+				net.queue = append(net.queue, envelope{to: int(to), m: m})
+			},
+		})
+		require.NoError(t, err)
+		net.nodes[i] = s
+	}
+	return net
+}
+
+// deliver delivers all queued messages including the ones produced during
+// delivery.
+func (n *memNetwork) deliver() {
+	for len(n.queue) > 0 {
+		e := n.queue[0]
+		n.queue = n.queue[1:]
+		if n.drop != nil && n.drop(e.to, e.m) {
+			continue
+		}
+		_ = n.nodes[e.to].OnMessage(e.m)
+	}
+}
+
+// run executes the whole protocol for nodes from the given set.
+func (n *memNetwork) run(t *testing.T, dealers ...int) []*KeyShare {
+	for _, i := range dealers {
+		require.NoError(t, n.nodes[i].Deal())
+	}
+	n.deliver()
+	for _, i := range dealers {
+		require.NoError(t, n.nodes[i].Complain())
+	}
+	n.deliver()
+	for _, i := range dealers {
+		require.NoError(t, n.nodes[i].Agree())
+	}
+	n.deliver()
+
+	res := make([]*KeyShare, len(n.nodes))
+	for _, i := range dealers {
+		ks, err := n.nodes[i].Finalize()
+		if n.faulty[i] {
+			continue
+		}
+		require.NoError(t, err)
+		res[i] = ks
+	}
+	return res
+}
+
+func allNodes(n int) []int {
+	res := make([]int, n)
+	for i := range res {
+		res[i] = i
+	}
+	return res
+}
+
+func requireConsistent(t *testing.T, shares []*KeyShare, threshold int) {
+	var first *KeyShare
+	for _, ks := range shares {
+		if ks == nil {
+			continue
+		}
+		if first == nil {
+			first = ks
+			continue
+		}
+		require.True(t, first.GroupKey().Equal(ks.GroupKey()))
+		require.Equal(t, first.Qualified, ks.Qualified)
+	}
+
+	// Every share must match its verification key.
+	for _, ks := range shares {
+		if ks != nil {
+			require.Equal(t, 0, expG(ks.secret).Cmp(first.VerificationKey(ks.Index).y))
+		}
+	}
+
+	// Any threshold subset of shares recovers the same shared secret.
+	eph, secret, err := first.GroupKey().Encrypt(big.NewInt(42).Bytes())
+	require.NoError(t, err)
+
+	var ds [][]byte
+	for _, ks := range shares {
+		if ks == nil {
+			continue
+		}
+		d, err := ks.DecryptionShare(eph)
+		require.NoError(t, err)
+		// Every participant can check others' shares.
+		require.NoError(t, first.VerifyDecryptionShare(ks.Index, eph, d))
+		ds = append(ds, d)
+	}
+	res, err := Combine(ds, threshold)
+	require.NoError(t, err)
+	require.Equal(t, secret, res)
+
+	ds = ds[1:]
+	if len(ds) >= threshold {
+		res, err = Combine(ds, threshold)
+		require.NoError(t, err)
+		require.Equal(t, secret, res)
+	}
+	ds = ds[:threshold-1]
+	_, err = Combine(ds, threshold)
+	require.Error(t, err)
+	if len(ds) > 0 {
+		// Duplicated shares don't count.
+		_, err = Combine(append(ds, ds[0]), threshold)
+		require.Error(t, err)
+	}
+}
+
+func TestDKG_AllHonest(t *testing.T) {
+	for _, n := range []int{1, 4, 7} {
+		net := newMemNetwork(t, n, 0)
+		shares := net.run(t, allNodes(n)...)
+		for _, ks := range shares {
+			require.Len(t, ks.Qualified, n)
+			require.Equal(t, n-(n-1)/3, ks.Threshold)
+		}
+		requireConsistent(t, shares, n-(n-1)/3)
+	}
+}
+
+func TestDKG_SilentDealer(t *testing.T) {
+	net := newMemNetwork(t, 4, 0)
+	shares := net.run(t, 0, 1, 2)
+	for _, ks := range shares[:3] {
+		require.Equal(t, []int{0, 1, 2}, ks.Qualified)
+	}
+	requireConsistent(t, shares, 3)
+
+	t.Run("not enough dealers", func(t *testing.T) {
+		net := newMemNetwork(t, 4, 0)
+		for _, i := range []int{0, 1, 2} {
+			require.NoError(t, net.nodes[i].Deal())
+		}
+		// Node 0 doesn't get anything from others.
+		net.drop = func(to int, m *Message) bool { return to == 0 && m.Type == DealType }
+		net.deliver()
+		require.NoError(t, net.nodes[0].Complain())
+		require.ErrorIs(t, net.nodes[0].Agree(), ErrNotEnoughDealers)
+		_, err := net.nodes[0].Finalize()
+		require.ErrorIs(t, err, ErrWrongPhase)
+	})
+}
+
+func TestDKG_Agreement(t *testing.T) {
+	t.Run("missing deal is justified", func(t *testing.T) {
+		net := newMemNetwork(t, 4, 0)
+		// Dealer 3 only sends its deal to node 0, others get its
+		// commitments from justifications.
+		net.drop = func(to int, m *Message) bool {
+			return m.From == 3 && m.Type == DealType && to != 0
+		}
+		shares := net.run(t, allNodes(4)...)
+		for _, ks := range shares {
+			require.Len(t, ks.Qualified, 4)
+		}
+		requireConsistent(t, shares, 3)
+	})
+
+	t.Run("inconsistent deal", func(t *testing.T) {
+		net := newMemNetwork(t, 4, 0)
+		net.faulty = map[int]bool{3: true}
+		// Dealer 3 only sends its deal to node 0 and never answers
+		// complaints, so node 0 excludes it along with others.
+		net.drop = func(to int, m *Message) bool {
+			return m.From == 3 && (m.Type == DealType && to != 0 || m.Type == JustificationType)
+		}
+		shares := net.run(t, allNodes(4)...)
+		for _, ks := range shares[:3] {
+			require.Equal(t, []int{0, 1, 2}, ks.Qualified)
+		}
+		requireConsistent(t, shares, 3)
+	})
+
+	t.Run("different justification commitments", func(t *testing.T) {
+		net := newMemNetwork(t, 4, 0)
+		net.faulty = map[int]bool{3: true}
+		fake := [][]byte{expG(big.NewInt(1)).Bytes(), expG(big.NewInt(2)).Bytes(), expG(big.NewInt(3)).Bytes()}
+		net.drop = func(to int, m *Message) bool {
+			if m.From == 3 && m.Type == ShareType && to == 1 {
+				return true
+			}
+			if m.From == 3 && m.Type == JustificationType {
+				m.Commitments = fake
+			}
+			return false
+		}
+		shares := net.run(t, allNodes(4)...)
+		for _, ks := range shares[:3] {
+			require.Equal(t, []int{0, 1, 2}, ks.Qualified)
+		}
+		requireConsistent(t, shares, 3)
+	})
+
+	t.Run("no agreement", func(t *testing.T) {
+		net := newMemNetwork(t, 4, 0)
+		for i := range net.nodes {
+			require.NoError(t, net.nodes[i].Deal())
+		}
+		net.deliver()
+		for i := range net.nodes {
+			require.NoError(t, net.nodes[i].Complain())
+		}
+		net.deliver()
+		// Node 0 has a different QUAL view (e.g. it got a complaint nobody
+		// else got).
+		net.nodes[0].dealers[3].disqualified = true
+		for i := range net.nodes {
+			require.NoError(t, net.nodes[i].Agree())
+		}
+		net.deliver()
+		_, err := net.nodes[0].Finalize()
+		require.ErrorIs(t, err, ErrNoAgreement)
+		shares := make([]*KeyShare, 4)
+		for i := 1; i < 4; i++ {
+			shares[i], err = net.nodes[i].Finalize()
+			require.NoError(t, err)
+		}
+		requireConsistent(t, shares, 3)
+	})
+}
+
+func TestDKG_DecryptionShare(t *testing.T) {
+	net := newMemNetwork(t, 4, 0)
+	shares := net.run(t, allNodes(4)...)
+	eph, _, err := shares[0].GroupKey().Encrypt(big.NewInt(42).Bytes())
+	require.NoError(t, err)
+
+	d, err := shares[1].DecryptionShare(eph)
+	require.NoError(t, err)
+	require.Len(t, d, DecryptionShareSize)
+	require.NoError(t, shares[0].VerifyDecryptionShare(1, eph, d))
+	require.Error(t, shares[0].VerifyDecryptionShare(2, eph, d))
+	require.Error(t, shares[0].VerifyDecryptionShare(4, eph, d))
+	require.Error(t, shares[0].VerifyDecryptionShare(1, eph, d[1:]))
+
+	other, _, err := shares[0].GroupKey().Encrypt(big.NewInt(43).Bytes())
+	require.NoError(t, err)
+	require.Error(t, shares[0].VerifyDecryptionShare(1, other, d))
+
+	// Share is replaced with a valid group element.
+	bad := slices.Clone(d)
+	expG(big.NewInt(5)).FillBytes(bad[2 : 2+elementSize])
+	require.Error(t, shares[0].VerifyDecryptionShare(1, eph, bad))
+}
+
+func TestDKG_Complaints(t *testing.T) {
+	t.Run("lost share is justified", func(t *testing.T) {
+		net := newMemNetwork(t, 4, 0)
+		net.drop = func(to int, m *Message) bool { return to == 2 && m.Type == ShareType && m.From == 1 }
+		shares := net.run(t, allNodes(4)...)
+		for _, ks := range shares {
+			require.Len(t, ks.Qualified, 4)
+		}
+		requireConsistent(t, shares, 3)
+	})
+
+	t.Run("bad share, dealer disqualified", func(t *testing.T) {
+		net := newMemNetwork(t, 4, 0)
+		var malicious = 3
+		net.faulty = map[int]bool{malicious: true}
+		net.drop = func(to int, m *Message) bool {
+			if int(m.From) == malicious && (m.Type == ShareType || m.Type == JustificationType) && m.Target == 1 {
+				m.Share = big.NewInt(1).Bytes()
+			}
+			return false
+		}
+		shares := net.run(t, allNodes(4)...)
+		// Malicious node has its own view and fails to finalize.
+		require.Nil(t, shares[malicious])
+		for _, ks := range shares[:malicious] {
+			require.Equal(t, []int{0, 1, 2}, ks.Qualified)
+		}
+		requireConsistent(t, shares, 3)
+	})
+
+	t.Run("false complaint", func(t *testing.T) {
+		net := newMemNetwork(t, 4, 0)
+		shares := make([]*KeyShare, 4)
+		for i := range net.nodes {
+			require.NoError(t, net.nodes[i].Deal())
+		}
+		net.deliver()
+		for i := range net.nodes {
+			require.NoError(t, net.nodes[i].Complain())
+		}
+		// Node 3 accuses honest node 0.
+		for _, to := range []int{1, 2} {
+			net.queue = append(net.queue, envelope{to: to, m: &Message{Type: ComplaintType, From: 3, Target: 0}})
+		}
+		net.queue = append(net.queue, envelope{to: 0, m: &Message{Type: ComplaintType, From: 3, Target: 0}})
+		net.deliver()
+		for i := range net.nodes {
+			require.NoError(t, net.nodes[i].Agree())
+		}
+		net.deliver()
+		for i := range net.nodes {
+			ks, err := net.nodes[i].Finalize()
+			require.NoError(t, err)
+			require.Len(t, ks.Qualified, 4)
+			shares[i] = ks
+		}
+		requireConsistent(t, shares, 3)
+	})
+}
+
+func TestDKG_Errors(t *testing.T) {
+	_, pub := crypto.Generate(rand.Reader)
+	var (
+		pubs = []dbft.PublicKey{pub, pub}
+		bc   = func(*Message) {}
+		send = func(uint16, *Message) {}
+	)
+
+	_, err := New(Config{MyIndex: 0, Broadcast: bc, Send: send})
+	require.Error(t, err)
+	_, err = New(Config{Validators: pubs, MyIndex: 2, Broadcast: bc, Send: send})
+	require.Error(t, err)
+	_, err = New(Config{Validators: pubs, Threshold: 3, Broadcast: bc, Send: send})
+	require.Error(t, err)
+	_, err = New(Config{Validators: pubs, Send: send})
+	require.Error(t, err)
+	_, err = New(Config{Validators: pubs, Broadcast: bc})
+	require.Error(t, err)
+
+	s, err := New(Config{Validators: pubs, Broadcast: bc, Send: send})
+	require.NoError(t, err)
+	require.ErrorIs(t, s.Complain(), ErrWrongPhase)
+	require.ErrorIs(t, s.Agree(), ErrWrongPhase)
+	_, err = s.Finalize()
+	require.ErrorIs(t, err, ErrWrongPhase)
+
+	require.NoError(t, s.Deal())
+	require.ErrorIs(t, s.Deal(), ErrWrongPhase)
+	require.Error(t, s.OnMessage(&Message{Type: DealType, From: 0}))
+	require.Error(t, s.OnMessage(&Message{Type: DealType, From: 1, Commitments: [][]byte{{1}, {2}}}))
+	require.Error(t, s.OnMessage(&Message{Type: ShareType, From: 1, Target: 0, Share: q.Bytes()}))
+	require.Error(t, s.OnMessage(&Message{Type: JustificationType, From: 1, Target: 0}))
+	require.Error(t, s.OnMessage(&Message{Type: QualType, From: 1, Digest: []byte{1}}))
+	require.Error(t, s.OnMessage(&Message{Type: 0xff, From: 1}))
+}
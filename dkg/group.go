@@ -0,0 +1,135 @@
+package dkg
+
+import (
+	"crypto/rand"
+	"io"
+	"math/big"
+)
+
+// modp2048 is a 2048-bit MODP group prime from RFC 3526 (group 14). It's a
+// safe prime, i.e. p = 2q + 1 where q is also prime.
+const modp2048 = "FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1" +
+	"29024E088A67CC74020BBEA63B139B22514A08798E3404DD" +
+	"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245" +
+	"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED" +
+	"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3D" +
+	"C2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F" +
+	"83655D23DCA3AD961C62F356208552BB9ED529077096966D" +
+	"670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B" +
+	"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9" +
+	"DE2BCBF6955817183995497CEA956AE515D2261898FA0510" +
+	"15728E5A8AACAA68FFFFFFFFFFFFFFFF"
+
+var (
+	// p is the group modulus.
+	p, _ = new(big.Int).SetString(modp2048, 16)
+	// q is the order of the prime-order subgroup used for keys and shares.
+	q = new(big.Int).Rsh(p, 1)
+	// g generates the subgroup of order q (any quadratic residue except 1
+	// does, 4 = 2² is the simplest one).
+	g = big.NewInt(4)
+)
+
+// randScalar returns a uniformly distributed non-zero scalar modulo q.
+func randScalar(r io.Reader) (*big.Int, error) {
+	for {
+		k, err := rand.Int(r, q)
+		if err != nil {
+			return nil, err
+		}
+		if k.Sign() != 0 {
+			return k, nil
+		}
+	}
+}
+
+// expG returns g^k mod p.
+func expG(k *big.Int) *big.Int {
+	return new(big.Int).Exp(g, k, p)
+}
+
+// isGroupElement checks that x belongs to the subgroup of order q.
+func isGroupElement(x *big.Int) bool {
+	if x.Sign() <= 0 || x.Cmp(p) >= 0 || x.Cmp(big.NewInt(1)) == 0 {
+		return false
+	}
+	return new(big.Int).Exp(x, q, p).Cmp(big.NewInt(1)) == 0
+}
+
+// polynomial is a polynomial over Z_q with coefficients stored from the
+// constant term upwards.
+type polynomial []*big.Int
+
+func randPolynomial(r io.Reader, degree int) (polynomial, error) {
+	poly := make(polynomial, degree+1)
+	for i := range poly {
+		k, err := randScalar(r)
+		if err != nil {
+			return nil, err
+		}
+		poly[i] = k
+	}
+	return poly, nil
+}
+
+// eval evaluates polynomial at x using Horner's method.
+func (f polynomial) eval(x int64) *big.Int {
+	var (
+		res = new(big.Int)
+		bx  = big.NewInt(x)
+	)
+	for i := len(f) - 1; i >= 0; i-- {
+		res.Mul(res, bx)
+		res.Add(res, f[i])
+		res.Mod(res, q)
+	}
+	return res
+}
+
+// commit returns Feldman commitments g^a_k for every coefficient a_k.
+func (f polynomial) commit() []*big.Int {
+	res := make([]*big.Int, len(f))
+	for i := range f {
+		res[i] = expG(f[i])
+	}
+	return res
+}
+
+// evalCommitments evaluates polynomial commitments "in the exponent", i.e.
+// returns g^f(x) given g^a_k for all coefficients a_k of f.
+func evalCommitments(comms []*big.Int, x int64) *big.Int {
+	var (
+		res = big.NewInt(1)
+		pw  = big.NewInt(1)
+		bx  = big.NewInt(x)
+		t   = new(big.Int)
+	)
+	for _, c := range comms {
+		t.Exp(c, pw, p)
+		res.Mul(res, t)
+		res.Mod(res, p)
+		pw.Mul(pw, bx)
+	}
+	return res
+}
+
+// lagrangeAtZero returns Lagrange coefficient for point xs[i] interpolating
+// at zero over Z_q.
+func lagrangeAtZero(xs []int64, i int) *big.Int {
+	var (
+		num = big.NewInt(1)
+		den = big.NewInt(1)
+		xi  = big.NewInt(xs[i])
+	)
+	for j, x := range xs {
+		if j == i {
+			continue
+		}
+		xj := big.NewInt(x)
+		num.Mul(num, xj)
+		num.Mod(num, q)
+		den.Mul(den, new(big.Int).Sub(xj, xi))
+		den.Mod(den, q)
+	}
+	return num.Mul(num, den.ModInverse(den, q)).Mod(num, q)
+}
@@ -0,0 +1,241 @@
+package dkg
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+)
+
+const (
+	// elementSize is the size of serialized group element or scalar.
+	elementSize = 256
+	// DecryptionShareSize is the size of decryption share produced by
+	// [KeyShare.DecryptionShare]: participant index, the share itself and
+	// its DLEQ proof.
+	DecryptionShareSize = 2 + elementSize + sha256.Size + elementSize
+)
+
+// KeyShare is the result of DKG for a single participant. It's used by
+// PreBlock implementations of anti-MEV extension to produce PreCommit data
+// with [KeyShare.DecryptionShare] and to check other validators' data with
+// [KeyShare.VerifyDecryptionShare]. Threshold decryption shares are then
+// combined with [Combine] to get the shared secret.
+type KeyShare struct {
+	// Index is the participant's index in the validators list.
+	Index int
+	// Threshold is the number of shares required to use the key.
+	Threshold int
+	// Qualified contains indexes of dealers that contributed to the key.
+	Qualified []int
+
+	secret    *big.Int
+	groupKey  *big.Int
+	verifKeys []*big.Int
+}
+
+// PublicKey is a public part of group key or its share.
+type PublicKey struct {
+	y *big.Int
+}
+
+func newKeyShare(index, n, threshold int, qual []int, secret *big.Int, comms [][]*big.Int) *KeyShare {
+	var (
+		groupKey  = big.NewInt(1)
+		verifKeys = make([]*big.Int, n)
+	)
+	for _, c := range comms {
+		groupKey.Mul(groupKey, c[0])
+		groupKey.Mod(groupKey, p)
+	}
+	for i := range verifKeys {
+		y := big.NewInt(1)
+		for _, c := range comms {
+			y.Mul(y, evalCommitments(c, int64(i)+1))
+			y.Mod(y, p)
+		}
+		verifKeys[i] = y
+	}
+	return &KeyShare{
+		Index:     index,
+		Threshold: threshold,
+		Qualified: qual,
+		secret:    secret,
+		groupKey:  groupKey,
+		verifKeys: verifKeys,
+	}
+}
+
+// GroupKey returns the resulting public key that is the same for all
+// participants.
+func (k *KeyShare) GroupKey() *PublicKey {
+	return &PublicKey{y: k.groupKey}
+}
+
+// VerificationKey returns public key corresponding to the share of
+// participant i, it can be used to check participants' shares.
+func (k *KeyShare) VerificationKey(i int) *PublicKey {
+	if i < 0 || i >= len(k.verifKeys) {
+		return nil
+	}
+	return &PublicKey{y: k.verifKeys[i]}
+}
+
+// Bytes returns serialized public key.
+func (pub *PublicKey) Bytes() []byte {
+	return pub.y.Bytes()
+}
+
+// Equal checks whether two public keys are the same.
+func (pub *PublicKey) Equal(other *PublicKey) bool {
+	return pub.y.Cmp(other.y) == 0
+}
+
+// Encrypt generates a random ephemeral key for the group key and returns its
+// public part (that is to be published) and the shared secret that can only be
+// recovered by Threshold participants with [KeyShare.DecryptionShare] and
+// [Combine]. It's a KEM part of threshold ElGamal encryption.
+func (pub *PublicKey) Encrypt(r []byte) (ephemeral []byte, secret []byte, err error) {
+	k := new(big.Int).SetBytes(r)
+	k.Mod(k, q)
+	if k.Sign() == 0 {
+		return nil, nil, errors.New("zero ephemeral key")
+	}
+	return expG(k).Bytes(), new(big.Int).Exp(pub.y, k, p).Bytes(), nil
+}
+
+// DecryptionShare returns participant's share of the shared secret for the
+// given ephemeral key produced by [PublicKey.Encrypt]. The share includes
+// participant index and a proof of its validity (Chaum-Pedersen proof of
+// equality of discrete logarithms of the share and participant's
+// verification key), so it can be checked by others with
+// [KeyShare.VerifyDecryptionShare].
+func (k *KeyShare) DecryptionShare(ephemeral []byte) ([]byte, error) {
+	u := new(big.Int).SetBytes(ephemeral)
+	if !isGroupElement(u) {
+		return nil, errors.New("invalid ephemeral key")
+	}
+	r, err := randScalar(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't generate proof: %w", err)
+	}
+	var (
+		d = new(big.Int).Exp(u, k.secret, p)
+		c = dleqChallenge(k.verifKeys[k.Index], u, d, expG(r), new(big.Int).Exp(u, r, p))
+		z = new(big.Int).Mul(c, k.secret)
+	)
+	z.Add(z, r).Mod(z, q)
+
+	res := make([]byte, DecryptionShareSize)
+	binary.BigEndian.PutUint16(res, uint16(k.Index))
+	d.FillBytes(res[2 : 2+elementSize])
+	c.FillBytes(res[2+elementSize : 2+elementSize+sha256.Size])
+	z.FillBytes(res[2+elementSize+sha256.Size:])
+	return res, nil
+}
+
+// VerifyDecryptionShare checks that share is a valid decryption share of
+// participant i for the given ephemeral key.
+func (k *KeyShare) VerifyDecryptionShare(i int, ephemeral []byte, share []byte) error {
+	if i < 0 || i >= len(k.verifKeys) {
+		return fmt.Errorf("unknown participant %d", i)
+	}
+	idx, d, err := decodeDecryptionShare(share)
+	if err != nil {
+		return err
+	}
+	if idx != i {
+		return fmt.Errorf("share of participant %d, expected %d", idx, i)
+	}
+	u := new(big.Int).SetBytes(ephemeral)
+	if !isGroupElement(u) {
+		return errors.New("invalid ephemeral key")
+	}
+	var (
+		off = 2 + elementSize
+		c   = new(big.Int).SetBytes(share[off : off+sha256.Size])
+		z   = new(big.Int).SetBytes(share[off+sha256.Size:])
+		vk  = k.verifKeys[i]
+		// a1 = g^z / vk^c, a2 = u^z / d^c.
+		a1 = new(big.Int).Exp(vk, new(big.Int).Sub(q, c), p)
+		a2 = new(big.Int).Exp(d, new(big.Int).Sub(q, c), p)
+	)
+	if c.Cmp(q) >= 0 || z.Cmp(q) >= 0 {
+		return errors.New("invalid proof")
+	}
+	a1.Mul(a1, expG(z)).Mod(a1, p)
+	a2.Mul(a2, new(big.Int).Exp(u, z, p)).Mod(a2, p)
+	if dleqChallenge(vk, u, d, a1, a2).Cmp(c) != 0 {
+		return errors.New("invalid proof")
+	}
+	return nil
+}
+
+// dleqChallenge returns Fiat-Shamir challenge for the proof of
+// log_g(vk) = log_u(d) with commitments a1 and a2.
+func dleqChallenge(vk, u, d, a1, a2 *big.Int) *big.Int {
+	var (
+		h   = sha256.New()
+		buf = make([]byte, elementSize)
+	)
+	for _, x := range []*big.Int{g, vk, u, d, a1, a2} {
+		h.Write(x.FillBytes(buf))
+	}
+	return new(big.Int).SetBytes(h.Sum(nil))
+}
+
+// decodeDecryptionShare returns participant index and the share itself from
+// serialized decryption share.
+func decodeDecryptionShare(share []byte) (int, *big.Int, error) {
+	if len(share) != DecryptionShareSize {
+		return 0, nil, fmt.Errorf("invalid share length %d", len(share))
+	}
+	d := new(big.Int).SetBytes(share[2 : 2+elementSize])
+	if !isGroupElement(d) {
+		return 0, nil, errors.New("share is not in group")
+	}
+	return int(binary.BigEndian.Uint16(share)), d, nil
+}
+
+// Combine recovers shared secret from at least threshold decryption shares
+// of different participants produced by [KeyShare.DecryptionShare]. It
+// doesn't check shares validity (it's done with
+// [KeyShare.VerifyDecryptionShare]), invalid shares lead to invalid secret.
+func Combine(shares [][]byte, threshold int) ([]byte, error) {
+	var (
+		xs []int64
+		ds []*big.Int
+	)
+	for _, sh := range shares {
+		if len(xs) == threshold {
+			break
+		}
+		i, d, err := decodeDecryptionShare(sh)
+		if err != nil {
+			return nil, err
+		}
+		x := int64(i) + 1
+		if slices.Contains(xs, x) {
+			continue
+		}
+		xs = append(xs, x)
+		ds = append(ds, d)
+	}
+	if len(xs) < threshold {
+		return nil, fmt.Errorf("not enough shares: %d of %d", len(xs), threshold)
+	}
+
+	var (
+		res = big.NewInt(1)
+		t   = new(big.Int)
+	)
+	for i := range ds {
+		t.Exp(ds[i], lagrangeAtZero(xs, i), p)
+		res.Mul(res, t)
+		res.Mod(res, p)
+	}
+	return res.Bytes(), nil
+}
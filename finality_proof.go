@@ -0,0 +1,144 @@
+package dbft
+
+import (
+	"errors"
+	"fmt"
+)
+
+// FinalityProof is a proof of block acceptance by validators. It contains
+// Commit signatures collected by dBFT for the block (or preparation signatures
+// of all validators for blocks approved via fast path, see FastPath) and can be
+// checked with VerifyFinalityProof by light clients that don't run consensus.
+type FinalityProof[H Hash] struct {
+	// BlockHash is a hash of the accepted block.
+	BlockHash H
+	// Height is an index of the accepted block.
+	Height uint32
+	// View is a view number the block was accepted at.
+	View View
+	// ValidatorsHash is a hash of validators list the block was accepted by
+	// computed with Config.ValidatorsHash.
+	ValidatorsHash H
+	// Signers are sorted indexes of validators that signed the block.
+	Signers []uint16
+	// Signatures are block signatures of the corresponding Signers.
+	Signatures [][]byte
+	// FastPath is true if the block was approved via fast path, Signatures
+	// are preparation signatures (see PreparationSigner) of all validators
+	// then. Preparation signatures are never valid as Commit ones, so such
+	// a proof is always checked as a separate kind of certificate.
+	FastPath bool
+}
+
+// makeFinalityProof returns FinalityProof for the current block based on
+// Commits received at the current view or on preparations of all validators
+// if the block is approved via fast path.
+func (c *Context[H]) makeFinalityProof() *FinalityProof[H] {
+	p := &FinalityProof[H]{
+		BlockHash:      c.block.Hash(),
+		Height:         c.BlockIndex,
+		View:           c.ViewNumber,
+		ValidatorsHash: c.Config.ValidatorsHash(c.Validators),
+	}
+
+	if c.FastPathApproved {
+		p.FastPath = true
+		for i, msg := range c.PreparationPayloads {
+			if msg == nil {
+				continue
+			}
+			// Signatures are checked by checkFastPath.
+			p.Signers = append(p.Signers, uint16(i))
+			p.Signatures = append(p.Signatures, preparation(msg).(SignedPreparation).Signature())
+		}
+		return p
+	}
+
+	for i, msg := range c.CommitPayloads {
+		if msg != nil && msg.ViewNumber() == c.ViewNumber {
+			p.Signers = append(p.Signers, uint16(i))
+			p.Signatures = append(p.Signatures, msg.GetCommit().Signature())
+		}
+	}
+
+	return p
+}
+
+// VerifyFinalityProof checks that p proves acceptance of the block b by the
+// given validators. Validators list must match the one the block was accepted
+// by, validatorsHash must be the same function as Config.ValidatorsHash and
+// weights must be the same as returned by Config.ValidatorWeights (nil means
+// equal weights). Signatures are checked with Block.Verify, they must be
+// provided by validators with more than 2/3 of the total weight. Fast path
+// proofs are checked with PreparationSigner.VerifyPreparation instead and
+// must be signed by all validators (except zero-weight ones).
+func VerifyFinalityProof[H Hash](p *FinalityProof[H], b Block[H], validators []PublicKey, validatorsHash func([]PublicKey) H, weights []uint64) error {
+	if p == nil {
+		return errors.New("nil proof")
+	}
+	if h := b.Hash(); p.BlockHash != h {
+		return fmt.Errorf("block hash mismatch: expected %s, got %s", h, p.BlockHash)
+	}
+	if p.Height != b.Index() {
+		return fmt.Errorf("height mismatch: expected %d, got %d", b.Index(), p.Height)
+	}
+	if h := validatorsHash(validators); p.ValidatorsHash != h {
+		return fmt.Errorf("validators hash mismatch: expected %s, got %s", h, p.ValidatorsHash)
+	}
+	if weights != nil && len(weights) != len(validators) {
+		return fmt.Errorf("invalid weights count: expected %d, got %d", len(validators), len(weights))
+	}
+	if len(p.Signers) != len(p.Signatures) {
+		return fmt.Errorf("signers and signatures count mismatch: %d != %d", len(p.Signers), len(p.Signatures))
+	}
+
+	var weight = func(i int) uint64 {
+		if weights == nil {
+			return 1
+		}
+		return weights[i]
+	}
+
+	var ps PreparationSigner
+	if p.FastPath {
+		var ok bool
+		if ps, ok = b.(PreparationSigner); !ok {
+			return errors.New("fast path proof for a block without preparation signatures support")
+		}
+	}
+
+	var total, signed uint64
+	for i := range validators {
+		total += weight(i)
+	}
+	if total == 0 {
+		return errors.New("zero total weight")
+	}
+	for j, i := range p.Signers {
+		if int(i) >= len(validators) {
+			return fmt.Errorf("invalid signer index %d", i)
+		}
+		if j > 0 && i <= p.Signers[j-1] {
+			return errors.New("signers are not sorted or duplicated")
+		}
+		var err error
+		if p.FastPath {
+			err = ps.VerifyPreparation(validators[i], p.Signatures[j])
+		} else {
+			err = b.Verify(validators[i], p.Signatures[j])
+		}
+		if err != nil {
+			return fmt.Errorf("invalid signature of validator %d: %w", i, err)
+		}
+		signed += weight(int(i))
+	}
+
+	if p.FastPath && signed < total {
+		return fmt.Errorf("not enough preparation signatures: %d < %d", signed, total)
+	}
+	if m := total - (total-1)/3; signed < m {
+		return fmt.Errorf("not enough signatures: %d < %d", signed, m)
+	}
+
+	return nil
+}
@@ -1,5 +1,13 @@
 package dbft
 
+import (
+	"container/list"
+)
+
+// DefaultMaxSeenPayloads is the default number of recently processed payload
+// hashes kept for deduplication.
+const DefaultMaxSeenPayloads = 4096
+
 type (
 	// inbox is a structure storing messages from a single epoch.
 	inbox[H Hash] struct {
@@ -14,6 +22,14 @@ type (
 	cache[H Hash] struct {
 		mail map[uint32]*inbox[H]
 	}
+
+	// seenSet is an LRU set of hashes of recently processed payloads, it
+	// doesn't keep anything if capacity is zero.
+	seenSet[H Hash] struct {
+		capacity int
+		order    *list.List
+		items    map[H]*list.Element
+	}
 )
 
 func newInbox[H Hash]() *inbox[H] {
@@ -61,3 +77,52 @@ func (c *cache[H]) addMessage(m ConsensusPayload[H]) {
 		// Theoretically messages could be extracted.
 	}
 }
+
+func newSeenSet[H Hash](capacity int) *seenSet[H] {
+	return &seenSet[H]{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[H]*list.Element),
+	}
+}
+
+// has checks whether h was added and marks it as recently used if so.
+func (s *seenSet[H]) has(h H) bool {
+	e, ok := s.items[h]
+	if ok {
+		s.order.MoveToFront(e)
+	}
+	return ok
+}
+
+// add adds h to the set evicting the least recently used hash if the set is
+// full.
+func (s *seenSet[H]) add(h H) {
+	if s.capacity == 0 {
+		return
+	}
+	if e, ok := s.items[h]; ok {
+		s.order.MoveToFront(e)
+		return
+	}
+	if s.order.Len() >= s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(H))
+	}
+	s.items[h] = s.order.PushFront(h)
+}
+
+// remove removes h from the set.
+func (s *seenSet[H]) remove(h H) {
+	if e, ok := s.items[h]; ok {
+		s.order.Remove(e)
+		delete(s.items, h)
+	}
+}
+
+// reset removes all hashes from the set.
+func (s *seenSet[H]) reset() {
+	s.order.Init()
+	clear(s.items)
+}
@@ -21,10 +21,10 @@ func (hash) String() string {
 	return ""
 }
 
-func (p payloadStub) ViewNumber() byte {
+func (p payloadStub) ViewNumber() View {
 	panic("TODO")
 }
-func (p payloadStub) SetViewNumber(byte) {
+func (p payloadStub) SetViewNumber(View) {
 	panic("TODO")
 }
 func (p payloadStub) Type() MessageType {
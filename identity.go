@@ -1,7 +1,9 @@
 package dbft
 
 import (
+	"encoding"
 	"fmt"
+	"reflect"
 )
 
 type (
@@ -26,3 +28,71 @@ type (
 		fmt.Stringer
 	}
 )
+
+// keyID returns a map key identifying the given public key. Keys implementing
+// encoding.BinaryMarshaler or having Bytes() []byte method are identified by
+// their serialized form, others are used as is. false is returned for nil
+// keys and keys that can't be used as map keys.
+func keyID(k PublicKey) (any, bool) {
+	switch k := k.(type) {
+	case nil:
+		return nil, false
+	case encoding.BinaryMarshaler:
+		if b, err := k.MarshalBinary(); err == nil {
+			return string(b), true
+		}
+	case interface{ Bytes() []byte }:
+		return string(k.Bytes()), true
+	}
+	return k, reflect.ValueOf(k).Comparable()
+}
+
+// validatorState keeps per-validator state identified by public keys (see
+// keyID), so it follows validators when validators list changes.
+type validatorState[T any] struct {
+	m map[any]*T
+}
+
+// get returns state of the given validator creating it if needed. nil is
+// returned for keys that can't be identified.
+func (s *validatorState[T]) get(k PublicKey) *T {
+	id, ok := keyID(k)
+	if !ok {
+		return nil
+	}
+	if s.m == nil {
+		s.m = make(map[any]*T)
+	}
+	v, ok := s.m[id]
+	if !ok {
+		v = new(T)
+		s.m[id] = v
+	}
+	return v
+}
+
+// lookup returns state of the given validator or nil if there is none.
+func (s *validatorState[T]) lookup(k PublicKey) *T {
+	if id, ok := keyID(k); ok {
+		return s.m[id]
+	}
+	return nil
+}
+
+// retain drops state of validators not present in the given list.
+func (s *validatorState[T]) retain(validators []PublicKey) {
+	if len(s.m) == 0 {
+		return
+	}
+	var current = make(map[any]bool, len(validators))
+	for _, v := range validators {
+		if id, ok := keyID(v); ok {
+			current[id] = true
+		}
+	}
+	for id := range s.m {
+		if !current[id] {
+			delete(s.m, id)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"math"
 
 	"github.com/nspcc-dev/dbft"
+	"github.com/nspcc-dev/dbft/dkg"
 	"github.com/nspcc-dev/dbft/internal/crypto"
 	"github.com/nspcc-dev/dbft/internal/merkle"
 )
@@ -31,11 +32,21 @@ func NewAMEVBlock(pre dbft.PreBlock[crypto.Uint256], cnData [][]byte, m int) dbf
 	// Based on the provided cnData we'll add one more transaction to the resulting block.
 	// Some artificial rules of new tx creation are invented here, but in Neo X there will
 	// be well-defined custom rules for Envelope transactions.
-	var sum uint32
-	for i := range m {
-		sum += binary.BigEndian.Uint32(cnData[i])
+	var tx Tx64
+	if preB.threshold != nil {
+		secret, err := dkg.Combine(cnData, preB.threshold.key.Threshold)
+		if err != nil {
+			return nil
+		}
+		h := crypto.Hash256(secret)
+		tx = Tx64(binary.BigEndian.Uint64(h[:]) & math.MaxInt64)
+	} else {
+		var sum uint32
+		for i := range m {
+			sum += binary.BigEndian.Uint32(cnData[i])
+		}
+		tx = Tx64(math.MaxInt64 - int64(sum))
 	}
-	tx := Tx64(math.MaxInt64 - int64(sum))
 	res.transactions = append(preB.initialTransactions, &tx)
 
 	// Rebuild Merkle root for the new set of transactions.
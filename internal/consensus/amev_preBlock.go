@@ -3,21 +3,40 @@ package consensus
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 
 	"github.com/nspcc-dev/dbft"
+	"github.com/nspcc-dev/dbft/dkg"
 	"github.com/nspcc-dev/dbft/internal/crypto"
 	"github.com/nspcc-dev/dbft/internal/merkle"
 )
 
-type preBlock struct {
-	base
+type (
+	preBlock struct {
+		base
 
-	// A magic number CN nodes should exchange during Commit phase
-	// and used to construct the final list of transactions for amevBlock.
-	data uint32
+		// A magic number CN nodes should exchange during Commit phase
+		// and used to construct the final list of transactions for amevBlock.
+		data uint32
 
-	initialTransactions []dbft.Transaction[crypto.Uint256]
-}
+		// threshold is set for PreBlocks using threshold decryption
+		// instead of the magic number.
+		threshold *thresholdData
+
+		initialTransactions []dbft.Transaction[crypto.Uint256]
+	}
+
+	// thresholdData contains threshold key material of PreBlock.
+	thresholdData struct {
+		key        *dkg.KeyShare
+		validators []dbft.PublicKey
+		// ephemeral is the public part of ephemeral key CN nodes decrypt the
+		// shared secret for.
+		ephemeral []byte
+		// share is this node's decryption share.
+		share []byte
+	}
+)
 
 var _ dbft.PreBlock[crypto.Uint256] = new(preBlock)
 
@@ -47,20 +66,62 @@ func NewPreBlock(timestamp uint64, index uint32, prevHash crypto.Uint256, nonce
 	return pre
 }
 
+// NewThresholdPreBlock returns new preBlock that uses threshold decryption
+// with the given key share (produced by DKG run among the given validators)
+// to exchange data during Commit phase.
+func NewThresholdPreBlock(timestamp uint64, index uint32, prevHash crypto.Uint256, nonce uint64, txHashes []crypto.Uint256, key *dkg.KeyShare, validators []dbft.PublicKey) (dbft.PreBlock[crypto.Uint256], error) {
+	pre := NewPreBlock(timestamp, index, prevHash, nonce, txHashes).(*preBlock)
+
+	// An artificial rule for ephemeral key derivation, in Neo X it's a part of
+	// encrypted Envelope transaction.
+	var seed = make([]byte, 4)
+	binary.BigEndian.PutUint32(seed, index)
+	r := crypto.Hash256(append(prevHash[:], seed...))
+	eph, _, err := key.GroupKey().Encrypt(r[:])
+	if err != nil {
+		return nil, err
+	}
+	pre.threshold = &thresholdData{
+		key:        key,
+		validators: validators,
+		ephemeral:  eph,
+	}
+	return pre, nil
+}
+
 func (pre *preBlock) Data() []byte {
+	if pre.threshold != nil {
+		return pre.threshold.share
+	}
 	var res = make([]byte, 4)
 	binary.BigEndian.PutUint32(res, pre.data)
 	return res
 }
 
 func (pre *preBlock) SetData(_ dbft.PrivateKey) error {
+	if pre.threshold != nil {
+		share, err := pre.threshold.key.DecryptionShare(pre.threshold.ephemeral)
+		if err != nil {
+			return err
+		}
+		pre.threshold.share = share
+		return nil
+	}
 	// Just an artificial rule for data construction, it can be anything, and in Neo X
 	// it will be decrypted transactions fragments.
 	pre.data = pre.Index
 	return nil
 }
 
-func (pre *preBlock) Verify(_ dbft.PublicKey, data []byte) error {
+func (pre *preBlock) Verify(pub dbft.PublicKey, data []byte) error {
+	if pre.threshold != nil {
+		for i, v := range pre.threshold.validators {
+			if v.(*crypto.ECDSAPub).Equals(pub) {
+				return pre.threshold.key.VerifyDecryptionShare(i, pre.threshold.ephemeral, data)
+			}
+		}
+		return fmt.Errorf("unknown validator %v", pub)
+	}
 	if len(data) != 4 {
 		return errors.New("invalid data len")
 	}
@@ -3,6 +3,7 @@ package consensus
 import (
 	"bytes"
 	"encoding/gob"
+	"slices"
 
 	"github.com/nspcc-dev/dbft"
 	"github.com/nspcc-dev/dbft/internal/crypto"
@@ -38,7 +39,15 @@ type (
 	}
 )
 
-var _ dbft.Block[crypto.Uint256] = new(neoBlock)
+var (
+	_ dbft.Block[crypto.Uint256] = new(neoBlock)
+	_ dbft.PreparationSigner     = new(neoBlock)
+)
+
+// preparationPrefix is prepended to the block hash data signed in
+// preparations, so that preparation signatures are never valid block ones
+// (gob-encoded hash data never starts with it).
+var preparationPrefix = []byte("dBFT preparation")
 
 // PrevHash implements Block interface.
 func (b *neoBlock) PrevHash() crypto.Uint256 {
@@ -126,6 +135,20 @@ func (b *neoBlock) Verify(pub dbft.PublicKey, sign []byte) error {
 	return pub.(*crypto.ECDSAPub).Verify(data, sign)
 }
 
+// SignPreparation implements PreparationSigner interface.
+func (b *neoBlock) SignPreparation(key dbft.PrivateKey) ([]byte, error) {
+	return key.(signable).Sign(b.preparationData())
+}
+
+// VerifyPreparation implements PreparationSigner interface.
+func (b *neoBlock) VerifyPreparation(pub dbft.PublicKey, sign []byte) error {
+	return pub.(*crypto.ECDSAPub).Verify(b.preparationData(), sign)
+}
+
+func (b *neoBlock) preparationData() []byte {
+	return append(slices.Clone(preparationPrefix), b.GetHashData()...)
+}
+
 // Hash implements Block interface.
 func (b *neoBlock) Hash() (h crypto.Uint256) {
 	if b.hash != nil {
@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/nspcc-dev/dbft"
+	"github.com/nspcc-dev/dbft/dkg"
 	"github.com/nspcc-dev/dbft/internal/crypto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -59,6 +60,21 @@ func TestNeoBlock_Setters(t *testing.T) {
 
 	t.Run("sign with invalid private key", func(t *testing.T) {
 		require.Error(t, b.Sign(testKey{}))
+		_, err := b.SignPreparation(testKey{})
+		require.Error(t, err)
+	})
+
+	t.Run("preparation signature", func(t *testing.T) {
+		priv, pub := crypto.Generate(rand.Reader)
+
+		sig, err := b.SignPreparation(priv)
+		require.NoError(t, err)
+		require.NoError(t, b.VerifyPreparation(pub, sig))
+
+		// Preparation and block signatures are never interchangeable.
+		require.NoError(t, b.Sign(priv))
+		require.Error(t, b.Verify(pub, sig))
+		require.Error(t, b.VerifyPreparation(pub, b.Signature()))
 	})
 }
 
@@ -78,3 +94,77 @@ func (tx testTx) Hash() (h crypto.Uint256) {
 	binary.LittleEndian.PutUint64(h[:], uint64(tx))
 	return
 }
+
+func TestThresholdPreBlock(t *testing.T) {
+	const n = 4
+	type envelope struct {
+		to int
+		m  *dkg.Message
+	}
+	var (
+		pubs     = make([]dbft.PublicKey, n)
+		sessions = make([]*dkg.Session, n)
+		queue    []envelope
+	)
+	for i := range pubs {
+		_, pubs[i] = crypto.Generate(rand.Reader)
+	}
+	for i := range sessions {
+		s, err := dkg.New(dkg.Config{
+			Validators: pubs,
+			MyIndex:    i,
+			Broadcast: func(m *dkg.Message) {
+				for j := range n {
+					if j != i {
+						queue = append(queue, envelope{j, m})
+					}
+				}
+			},
+			Send: func(to uint16, m *dkg.Message) {
+				queue = append(queue, envelope{int(to), m})
+			},
+		})
+		require.NoError(t, err)
+		sessions[i] = s
+	}
+	deliver := func() {
+		for ; len(queue) > 0; queue = queue[1:] {
+			require.NoError(t, sessions[queue[0].to].OnMessage(queue[0].m))
+		}
+	}
+	for _, step := range []func(*dkg.Session) error{(*dkg.Session).Deal, (*dkg.Session).Complain, (*dkg.Session).Agree} {
+		for _, s := range sessions {
+			require.NoError(t, step(s))
+		}
+		deliver()
+	}
+
+	var (
+		pres = make([]dbft.PreBlock[crypto.Uint256], n)
+		data = make([][]byte, n)
+	)
+	for i, s := range sessions {
+		key, err := s.Finalize()
+		require.NoError(t, err)
+		pres[i], err = NewThresholdPreBlock(0, 1, crypto.Uint256{1}, 0, []crypto.Uint256{{2}}, key, pubs)
+		require.NoError(t, err)
+		require.NoError(t, pres[i].SetData(nil))
+		data[i] = pres[i].Data()
+	}
+	for i := range pres {
+		for j := range data {
+			require.NoError(t, pres[i].Verify(pubs[j], data[j]))
+		}
+		require.Error(t, pres[i].Verify(pubs[0], data[1]))
+	}
+	_, unknown := crypto.Generate(rand.Reader)
+	require.Error(t, pres[0].Verify(unknown, data[0]))
+
+	// Any M shares produce the same block.
+	b1 := NewAMEVBlock(pres[0], data[:3], 3)
+	b2 := NewAMEVBlock(pres[3], data[1:], 3)
+	require.NotNil(t, b1)
+	require.Len(t, b1.Transactions(), 1)
+	require.Equal(t, b1.MerkleRoot(), b2.MerkleRoot())
+	require.Nil(t, NewAMEVBlock(pres[0], data[:2], 2))
+}
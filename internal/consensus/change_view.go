@@ -8,7 +8,7 @@ import (
 
 type (
 	changeView struct {
-		newViewNumber byte
+		newViewNumber dbft.View
 		timestamp     uint32
 	}
 	// changeViewAux is an auxiliary structure for changeView encoding.
@@ -37,7 +37,7 @@ func (c *changeView) DecodeBinary(r *gob.Decoder) error {
 }
 
 // NewViewNumber implements ChangeView interface.
-func (c changeView) NewViewNumber() byte {
+func (c changeView) NewViewNumber() dbft.View {
 	return c.newViewNumber
 }
 
@@ -45,3 +45,8 @@ func (c changeView) NewViewNumber() byte {
 func (c changeView) Reason() dbft.ChangeViewReason {
 	return dbft.CVUnknown
 }
+
+// Timestamp implements ChangeView interface.
+func (c changeView) Timestamp() uint64 {
+	return secToNanoSec(c.timestamp)
+}
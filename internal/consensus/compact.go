@@ -2,29 +2,32 @@ package consensus
 
 import (
 	"encoding/gob"
+
+	"github.com/nspcc-dev/dbft"
 )
 
 type (
 	changeViewCompact struct {
 		ValidatorIndex     uint16
-		OriginalViewNumber byte
+		OriginalViewNumber dbft.View
 		Timestamp          uint32
 	}
 
 	preCommitCompact struct {
-		ViewNumber     byte
+		ViewNumber     dbft.View
 		ValidatorIndex uint16
 		Data           []byte
 	}
 
 	commitCompact struct {
-		ViewNumber     byte
+		ViewNumber     dbft.View
 		ValidatorIndex uint16
 		Signature      [signatureSize]byte
 	}
 
 	preparationCompact struct {
 		ValidatorIndex uint16
+		Signature      []byte
 	}
 )
 
@@ -18,7 +18,7 @@ type (
 
 	message struct {
 		cmType     dbft.MessageType
-		viewNumber byte
+		viewNumber dbft.View
 
 		payload any
 	}
@@ -26,7 +26,7 @@ type (
 	// messageAux is an auxiliary structure for message marshalling.
 	messageAux struct {
 		CMType     byte
-		ViewNumber byte
+		ViewNumber dbft.View
 		Payload    []byte
 	}
 )
@@ -95,7 +95,7 @@ func (m message) GetRecoveryMessage() dbft.RecoveryMessage[crypto.Uint256] {
 }
 
 // ViewNumber implements ConsensusMessage interface.
-func (m message) ViewNumber() byte {
+func (m message) ViewNumber() dbft.View {
 	return m.viewNumber
 }
 
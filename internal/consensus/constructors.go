@@ -8,7 +8,7 @@ import (
 )
 
 // NewConsensusPayload returns minimal ConsensusPayload implementation.
-func NewConsensusPayload(t dbft.MessageType, height uint32, validatorIndex uint16, viewNumber byte, consensusMessage any) dbft.ConsensusPayload[crypto.Uint256] {
+func NewConsensusPayload(t dbft.MessageType, height uint32, validatorIndex uint16, viewNumber dbft.View, consensusMessage any) dbft.ConsensusPayload[crypto.Uint256] {
 	return &Payload{
 		message: message{
 			cmType:     t,
@@ -37,7 +37,7 @@ func NewPrepareResponse(preparationHash crypto.Uint256) dbft.PrepareResponse[cry
 }
 
 // NewChangeView returns minimal ChangeView implementation.
-func NewChangeView(newViewNumber byte, _ dbft.ChangeViewReason, ts uint64) dbft.ChangeView {
+func NewChangeView(newViewNumber dbft.View, _ dbft.ChangeViewReason, ts uint64) dbft.ChangeView {
 	return &changeView{
 		newViewNumber: newViewNumber,
 		timestamp:     nanoSecToSec(ts),
@@ -96,6 +96,24 @@ func TestPayload_EncodeDecode(t *testing.T) {
 		testEncodeDecode(t, m, new(Payload))
 		testMarshalUnmarshal(t, m, new(Payload))
 	})
+
+	t.Run("targeted RecoveryRequest", func(t *testing.T) {
+		known := &dbft.KnownPayloads{
+			Preparations: dbft.NewBitmap(7),
+			PreCommits:   dbft.NewBitmap(7),
+			Commits:      dbft.NewBitmap(7),
+			ChangeViews:  dbft.NewBitmap(7),
+		}
+		known.Preparations.Set(1)
+		known.Commits.Set(6)
+		m := generateMessage(dbft.RecoveryRequestType, &recoveryRequest{
+			timestamp: 17334,
+			known:     known,
+		})
+
+		testEncodeDecode(t, m, new(Payload))
+		testMarshalUnmarshal(t, m, new(Payload))
+	})
 }
 
 func TestRecoveryMessage_NoPayloads(t *testing.T) {
@@ -12,16 +12,21 @@ type (
 		transactionHashes []crypto.Uint256
 		nonce             uint64
 		timestamp         uint32
+		signature         []byte
 	}
 	// prepareRequestAux is an auxiliary structure for prepareRequest encoding.
 	prepareRequestAux struct {
 		TransactionHashes []crypto.Uint256
 		Nonce             uint64
 		Timestamp         uint32
+		Signature         []byte
 	}
 )
 
-var _ dbft.PrepareRequest[crypto.Uint256] = (*prepareRequest)(nil)
+var (
+	_ dbft.PrepareRequest[crypto.Uint256] = (*prepareRequest)(nil)
+	_ dbft.SignedPreparation              = (*prepareRequest)(nil)
+)
 
 // EncodeBinary implements Serializable interface.
 func (p prepareRequest) EncodeBinary(w *gob.Encoder) error {
@@ -29,6 +34,7 @@ func (p prepareRequest) EncodeBinary(w *gob.Encoder) error {
 		TransactionHashes: p.transactionHashes,
 		Nonce:             p.nonce,
 		Timestamp:         p.timestamp,
+		Signature:         p.signature,
 	})
 }
 
@@ -42,6 +48,7 @@ func (p *prepareRequest) DecodeBinary(r *gob.Decoder) error {
 	p.timestamp = aux.Timestamp
 	p.nonce = aux.Nonce
 	p.transactionHashes = aux.TransactionHashes
+	p.signature = aux.Signature
 	return nil
 }
 
@@ -59,3 +66,13 @@ func (p prepareRequest) Nonce() uint64 {
 func (p prepareRequest) TransactionHashes() []crypto.Uint256 {
 	return p.transactionHashes
 }
+
+// Signature implements SignedPreparation interface.
+func (p *prepareRequest) Signature() []byte {
+	return p.signature
+}
+
+// SetSignature implements SignedPreparation interface.
+func (p *prepareRequest) SetSignature(sig []byte) {
+	p.signature = sig
+}
@@ -10,19 +10,25 @@ import (
 type (
 	prepareResponse struct {
 		preparationHash crypto.Uint256
+		signature       []byte
 	}
 	// prepareResponseAux is an auxiliary structure for prepareResponse encoding.
 	prepareResponseAux struct {
 		PreparationHash crypto.Uint256
+		Signature       []byte
 	}
 )
 
-var _ dbft.PrepareResponse[crypto.Uint256] = (*prepareResponse)(nil)
+var (
+	_ dbft.PrepareResponse[crypto.Uint256] = (*prepareResponse)(nil)
+	_ dbft.SignedPreparation               = (*prepareResponse)(nil)
+)
 
 // EncodeBinary implements Serializable interface.
 func (p prepareResponse) EncodeBinary(w *gob.Encoder) error {
 	return w.Encode(prepareResponseAux{
 		PreparationHash: p.preparationHash,
+		Signature:       p.signature,
 	})
 }
 
@@ -34,6 +40,7 @@ func (p *prepareResponse) DecodeBinary(r *gob.Decoder) error {
 	}
 
 	p.preparationHash = aux.PreparationHash
+	p.signature = aux.Signature
 	return nil
 }
 
@@ -41,3 +48,13 @@ func (p *prepareResponse) DecodeBinary(r *gob.Decoder) error {
 func (p *prepareResponse) PreparationHash() crypto.Uint256 {
 	return p.preparationHash
 }
+
+// Signature implements SignedPreparation interface.
+func (p *prepareResponse) Signature() []byte {
+	return p.signature
+}
+
+// SetSignature implements SignedPreparation interface.
+func (p *prepareResponse) SetSignature(sig []byte) {
+	p.signature = sig
+}
@@ -42,9 +42,13 @@ func (m *recoveryMessage) AddPayload(p dbft.ConsensusPayload[crypto.Uint256]) {
 		prepHash := p.Hash()
 		m.preparationHash = &prepHash
 	case dbft.PrepareResponseType:
-		m.preparationPayloads = append(m.preparationPayloads, preparationCompact{
+		pc := preparationCompact{
 			ValidatorIndex: p.ValidatorIndex(),
-		})
+		}
+		if sp, ok := p.GetPrepareResponse().(dbft.SignedPreparation); ok {
+			pc.Signature = sp.Signature()
+		}
+		m.preparationPayloads = append(m.preparationPayloads, pc)
 	case dbft.ChangeViewType:
 		m.changeViewPayloads = append(m.changeViewPayloads, changeViewCompact{
 			ValidatorIndex:     p.ValidatorIndex(),
@@ -87,12 +91,16 @@ func (m *recoveryMessage) GetPrepareRequest(p dbft.ConsensusPayload[crypto.Uint2
 		return nil
 	}
 
-	req := fromPayload(dbft.PrepareRequestType, p, &prepareRequest{
+	pr := &prepareRequest{
 		// prepareRequest.Timestamp() here returns nanoseconds-precision value, so convert it to seconds again
 		timestamp:         nanoSecToSec(m.prepareRequest.Timestamp()),
 		nonce:             m.prepareRequest.Nonce(),
 		transactionHashes: m.prepareRequest.TransactionHashes(),
-	})
+	}
+	if sp, ok := m.prepareRequest.(dbft.SignedPreparation); ok {
+		pr.signature = sp.Signature()
+	}
+	req := fromPayload(dbft.PrepareRequestType, p, pr)
 	req.SetValidatorIndex(ind)
 
 	return req
@@ -109,6 +117,7 @@ func (m *recoveryMessage) GetPrepareResponses(p dbft.ConsensusPayload[crypto.Uin
 	for i, resp := range m.preparationPayloads {
 		payloads[i] = fromPayload(dbft.PrepareResponseType, p, &prepareResponse{
 			preparationHash: *m.preparationHash,
+			signature:       resp.Signature,
 		})
 		payloads[i].SetValidatorIndex(resp.ValidatorIndex)
 	}
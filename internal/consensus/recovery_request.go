@@ -9,19 +9,22 @@ import (
 type (
 	recoveryRequest struct {
 		timestamp uint32
+		known     *dbft.KnownPayloads
 	}
 	// recoveryRequestAux is an auxiliary structure for recoveryRequest encoding.
 	recoveryRequestAux struct {
 		Timestamp uint32
+		Known     *dbft.KnownPayloads
 	}
 )
 
-var _ dbft.RecoveryRequest = (*recoveryRequest)(nil)
+var _ dbft.TargetedRecoveryRequest = (*recoveryRequest)(nil)
 
 // EncodeBinary implements Serializable interface.
 func (m recoveryRequest) EncodeBinary(w *gob.Encoder) error {
 	return w.Encode(&recoveryRequestAux{
 		Timestamp: m.timestamp,
+		Known:     m.known,
 	})
 }
 
@@ -33,6 +36,7 @@ func (m *recoveryRequest) DecodeBinary(r *gob.Decoder) error {
 	}
 
 	m.timestamp = aux.Timestamp
+	m.known = aux.Known
 	return nil
 }
 
@@ -40,3 +44,13 @@ func (m *recoveryRequest) DecodeBinary(r *gob.Decoder) error {
 func (m *recoveryRequest) Timestamp() uint64 {
 	return secToNanoSec(m.timestamp)
 }
+
+// Known implements TargetedRecoveryRequest interface.
+func (m *recoveryRequest) Known() *dbft.KnownPayloads {
+	return m.known
+}
+
+// SetKnown implements TargetedRecoveryRequest interface.
+func (m *recoveryRequest) SetKnown(k *dbft.KnownPayloads) {
+	m.known = k
+}
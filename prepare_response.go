@@ -6,3 +6,16 @@ type PrepareResponse[H Hash] interface {
 	// for this epoch.
 	PreparationHash() H
 }
+
+// SignedPreparation is an optional extension of PrepareRequest and
+// PrepareResponse carrying the preparation signature of the proposed block
+// header made by the sender (see PreparationSigner). It's required for fast
+// path (see Config.FastPathEnablingHeight), signatures of all validators form
+// the fast path block certificate then. dBFT sets the signature right after
+// the message is constructed.
+type SignedPreparation interface {
+	// Signature returns the preparation signature, it's nil if not set.
+	Signature() []byte
+	// SetSignature sets the preparation signature.
+	SetSignature(sig []byte)
+}
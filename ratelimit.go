@@ -0,0 +1,112 @@
+package dbft
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type (
+	// RateLimit is a token bucket rate limit: Burst events are allowed at
+	// once and Rate events per second are allowed after that. Zero Burst
+	// disables the limit.
+	RateLimit struct {
+		Rate  float64
+		Burst int
+	}
+
+	// rateLimiter keeps token buckets of every validator, validators are
+	// identified by their public keys.
+	rateLimiter struct {
+		limit   RateLimit
+		buckets validatorState[tokenBucket]
+	}
+
+	tokenBucket struct {
+		tokens float64
+		last   time.Time
+	}
+)
+
+// allow takes a token from the bucket of the validator with the given key
+// and returns false if there are none.
+func (l *rateLimiter) allow(validator PublicKey, now time.Time) bool {
+	if l.limit.Burst == 0 {
+		return true
+	}
+	b := l.buckets.get(validator)
+	if b == nil {
+		return true
+	}
+	if b.last.IsZero() {
+		b.tokens = float64(l.limit.Burst)
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(float64(l.limit.Burst), b.tokens+elapsed.Seconds()*l.limit.Rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// retainRateLimiters drops buckets of validators that are not in the given
+// list, buckets of others are kept across heights.
+func (d *DBFT[H]) retainRateLimiters(validators []PublicKey) {
+	d.changeViewLimiter.buckets.retain(validators)
+	d.recoveryRequestLimiter.buckets.retain(validators)
+	d.recoveryResponseLimiter.buckets.retain(validators)
+}
+
+// rateLimited checks whether ChangeView or RecoveryRequest msg exceeds the
+// rate limit of its sender from the given validators list. It's called right
+// before msg is accepted or answered, so ignored payloads don't spend tokens.
+// Payloads extracted from RecoveryMessage are not limited. Dropped payloads
+// are not considered to be seen, so they can be handled if received again.
+func (d *DBFT[H]) rateLimited(msg ConsensusPayload[H], validators []PublicKey) bool {
+	var l *rateLimiter
+	switch msg.Type() {
+	case ChangeViewType:
+		l = &d.changeViewLimiter
+	case RecoveryRequestType:
+		l = &d.recoveryRequestLimiter
+	default:
+		return false
+	}
+	if d.recovery != nil || l.allow(validators[msg.ValidatorIndex()], d.Timer.Now()) {
+		return false
+	}
+	d.seen.remove(msg.Hash())
+	d.reportRateLimited(int(msg.ValidatorIndex()), msg.Type())
+	return true
+}
+
+// sendRecoveryResponse sends RecoveryMessage in response to ChangeView or
+// RecoveryRequest msg to its sender unless msg exceeds its rate limit or
+// the response exceeds Config.RecoveryResponseRateLimit.
+func (d *DBFT[H]) sendRecoveryResponse(msg ConsensusPayload[H]) {
+	if d.rateLimited(msg, d.Validators) {
+		return
+	}
+	validator := int(msg.ValidatorIndex())
+	if !d.recoveryResponseLimiter.allow(d.Validators[validator], d.Timer.Now()) {
+		d.reportRateLimited(validator, RecoveryMessageType)
+		return
+	}
+	// Payloads known by the requester can only be skipped if nobody else
+	// receives the reply.
+	if d.SendTo == nil {
+		msg = nil
+	}
+	d.sendTo(validator, d.makeRecoveryMessage(msg))
+}
+
+func (d *DBFT[H]) reportRateLimited(validator int, t MessageType) {
+	d.Logger.Info("rate limit exceeded",
+		zap.Int("validator", validator),
+		zap.Stringer("type", t))
+	if d.OnRateLimited != nil {
+		d.OnRateLimited(validator, t)
+	}
+}
@@ -1,7 +1,54 @@
 package dbft
 
-// RecoveryRequest represents dBFT RecoveryRequest message.
-type RecoveryRequest interface {
-	// Timestamp returns this message's timestamp.
-	Timestamp() uint64
+type (
+	// RecoveryRequest represents dBFT RecoveryRequest message.
+	RecoveryRequest interface {
+		// Timestamp returns this message's timestamp.
+		Timestamp() uint64
+	}
+
+	// TargetedRecoveryRequest is an optional RecoveryRequest extension
+	// specifying payloads the sender already has, so that RecoveryMessage
+	// sent in response contains only the missing ones. dBFT sets known
+	// payloads for every RecoveryRequest created with
+	// Config.NewRecoveryRequest that implements this interface.
+	TargetedRecoveryRequest interface {
+		RecoveryRequest
+		// Known returns payloads the sender has, nil means nothing is known.
+		Known() *KnownPayloads
+		// SetKnown sets payloads the sender has.
+		SetKnown(k *KnownPayloads)
+	}
+
+	// KnownPayloads describes payloads of the view RecoveryRequest is sent
+	// at that its sender has. Every field is a bitmap of validator indexes
+	// having the corresponding payloads (PrepareRequest is a preparation
+	// payload of the primary). ChangeViews are the ones that lead to this
+	// view (see Context.LastChangeViewPayloads). Known payloads are only
+	// skipped in RecoveryMessage replies sent via Config.SendTo.
+	KnownPayloads struct {
+		Preparations Bitmap
+		PreCommits   Bitmap
+		Commits      Bitmap
+		ChangeViews  Bitmap
+	}
+
+	// Bitmap is a set of validator indexes.
+	Bitmap []byte
+)
+
+// NewBitmap returns an empty Bitmap for n validators.
+func NewBitmap(n int) Bitmap {
+	return make(Bitmap, (n+7)/8)
+}
+
+// Set adds validator index i to the bitmap, it must be less than the number
+// of validators the bitmap is created for.
+func (b Bitmap) Set(i int) {
+	b[i/8] |= 1 << (i % 8)
+}
+
+// IsSet checks whether validator index i is in the bitmap.
+func (b Bitmap) IsSet(i int) bool {
+	return i >= 0 && i/8 < len(b) && b[i/8]&(1<<(i%8)) != 0
 }
@@ -0,0 +1,164 @@
+package dbft
+
+// maxPenaltyShift limits the growth of demotion period for validators that
+// repeatedly fail to propose.
+const maxPenaltyShift = 16
+
+// Reputation tracks proposal liveness of validators across heights. It's
+// updated by dBFT every time a block is accepted: primaries of all views
+// preceding the one the block is accepted at are considered to have failed
+// to propose, while the primary of the accepted view gets its record cleared.
+//
+// The state is derived from accepted blocks only (their height, view and
+// validators), so that all nodes have the same statistics. It's not
+// persisted though, and dBFT only sees blocks it accepts itself, so the
+// caller must rebuild it from the ledger with AddBlock on startup and feed
+// it with blocks received by other means (like synchronization), otherwise
+// PrimarySelector results would differ between nodes.
+//
+// Validators are identified by their public keys (the same way RTTStats does),
+// so statistics follow validators when validators list changes. Statistics of
+// validators that are not in the list of the last added block are dropped.
+// Reputation is not safe for concurrent use, it's to be accessed with the same
+// synchronization as the rest of DBFT state.
+type Reputation struct {
+	stats map[any]ValidatorStats
+}
+
+// ValidatorStats contains liveness statistics of a single validator.
+type ValidatorStats struct {
+	// Proposed is the number of accepted blocks proposed by the validator.
+	Proposed uint32
+	// Missed is the number of consecutive proposals the validator failed
+	// to get accepted as primary.
+	Missed uint32
+	// LastMissed is the height of the last missed proposal.
+	LastMissed uint32
+}
+
+// NewReputation returns an empty Reputation instance to be passed to
+// WithReputation.
+func NewReputation() *Reputation {
+	return &Reputation{stats: make(map[any]ValidatorStats)}
+}
+
+// Stats returns statistics of the validator with the given key.
+func (r *Reputation) Stats(validator PublicKey) ValidatorStats {
+	id, ok := keyID(validator)
+	if !ok {
+		return ValidatorStats{}
+	}
+	return r.stats[id]
+}
+
+// Score returns reputation score of the validator with the given key. It's
+// zero for validators that proposed successfully last time they were primary
+// and decreases with every consecutive miss.
+func (r *Reputation) Score(validator PublicKey) int {
+	return -int(r.Stats(validator).Missed)
+}
+
+// AddBlock updates the statistics with the block accepted at the given
+// height and view by the given validators: primaries of all preceding views
+// missed their proposals and the primary of the view proposed the block.
+// selector must be the one used by consensus at this height. dBFT calls it
+// for every block it accepts, the caller must do the same for blocks
+// obtained by other means, the view is to be stored in the block for this.
+func (r *Reputation) AddBlock(height uint32, view View, validators []PublicKey, selector func(height uint32, view View, validators []PublicKey) uint) {
+	r.retain(validators)
+	// Compute all primaries before updating since selector can depend on
+	// the reputation itself.
+	var missed = make([]uint, 0, view)
+	for v := range view {
+		missed = append(missed, selector(height, v, validators))
+	}
+	primary := selector(height, view, validators)
+	for _, p := range missed {
+		r.RecordMiss(validators[p], height)
+	}
+	r.RecordProposal(validators[primary])
+}
+
+// RecordMiss records a failed proposal of the validator with the given key
+// at the given height. AddBlock is to be used in most cases instead.
+func (r *Reputation) RecordMiss(validator PublicKey, height uint32) {
+	r.update(validator, func(s *ValidatorStats) {
+		s.Missed++
+		s.LastMissed = height
+	})
+}
+
+// RecordProposal records an accepted proposal of the validator with the given
+// key. AddBlock is to be used in most cases instead.
+func (r *Reputation) RecordProposal(validator PublicKey) {
+	r.update(validator, func(s *ValidatorStats) {
+		s.Proposed++
+		s.Missed = 0
+	})
+}
+
+func (r *Reputation) update(validator PublicKey, f func(s *ValidatorStats)) {
+	id, ok := keyID(validator)
+	if !ok {
+		return
+	}
+	if r.stats == nil {
+		r.stats = make(map[any]ValidatorStats)
+	}
+	s := r.stats[id]
+	f(&s)
+	r.stats[id] = s
+}
+
+// PrimarySelector returns primary selection strategy that follows the default
+// round-robin order over validators that aren't demoted. A validator is
+// demoted for penalty heights after a missed proposal, this period doubles
+// with every consecutive miss. If all validators are demoted, the default
+// selection is used.
+//
+// The result is deterministic as long as all nodes have the same Reputation
+// state, that is they have accepted the same blocks via dBFT at the same
+// views. Nodes that get blocks by other means should restore the state using
+// AddBlock.
+func (r *Reputation) PrimarySelector(penalty uint32) func(height uint32, view View, validators []PublicKey) uint {
+	return func(height uint32, view View, validators []PublicKey) uint {
+		var eligible = make([]uint, 0, len(validators))
+		for i := range validators {
+			if !r.demoted(validators[i], height, penalty) {
+				eligible = append(eligible, uint(i))
+			}
+		}
+		if len(eligible) == 0 {
+			return DefaultPrimarySelector(height, view, validators)
+		}
+		p := (int(height) - int(view)) % len(eligible)
+		if p < 0 {
+			p += len(eligible)
+		}
+		return eligible[p]
+	}
+}
+
+func (r *Reputation) demoted(validator PublicKey, height uint32, penalty uint32) bool {
+	s := r.Stats(validator)
+	if s.Missed == 0 {
+		return false
+	}
+	period := uint64(penalty) << min(s.Missed-1, maxPenaltyShift)
+	return uint64(height) <= uint64(s.LastMissed)+period
+}
+
+// retain drops statistics of validators not present in the given list.
+func (r *Reputation) retain(validators []PublicKey) {
+	var current = make(map[any]bool, len(validators))
+	for _, v := range validators {
+		if id, ok := keyID(v); ok {
+			current[id] = true
+		}
+	}
+	for id := range r.stats {
+		if !current[id] {
+			delete(r.stats, id)
+		}
+	}
+}
@@ -1,26 +1,222 @@
 package dbft
 
 import (
+	"slices"
 	"time"
 )
 
-const rttLength = 7 * 10 // 10 rounds with 7 nodes
+// DefaultRTTWindow is the default number of measurements RTTStats keeps.
+const DefaultRTTWindow = 7 * 10 // 10 rounds with 7 nodes
 
-type rtt struct {
-	times [rttLength]time.Duration
-	idx   int
-	avg   time.Duration
+// RTTKind is a kind of round-trip time measurement. Measurements of different
+// kinds include different processing delays, so they're never mixed.
+type RTTKind byte
+
+const (
+	// PrepareRTT is measured by primary between PrepareRequest sending and
+	// PrepareResponse receiving. It's used for timer adjustments.
+	PrepareRTT RTTKind = iota
+	// CommitRTT is measured by backups between PrepareRequest receiving and
+	// Commit receiving, it also includes the time Commit sender needs to
+	// collect preparations.
+	CommitRTT
+
+	rttKinds = iota
+)
+
+type (
+	// RTTEstimator collects round-trip time measurements to other validators.
+	// Validators are identified by their public keys, so estimates stay valid
+	// when validators list changes.
+	RTTEstimator interface {
+		// Add records a round-trip time of the given kind measured for the
+		// validator with the given key.
+		Add(kind RTTKind, validator PublicKey, rtt time.Duration)
+		// Average returns average round-trip time of the given kind over
+		// recent measurements for all validators, it's zero if nothing is
+		// measured yet.
+		Average(kind RTTKind) time.Duration
+		// Estimate returns smoothed round-trip time of the given kind for
+		// the validator with the given key, it's zero if nothing is measured
+		// yet.
+		Estimate(kind RTTKind, validator PublicKey) time.Duration
+		// Percentile returns p-th (0 to 100) percentile of recent
+		// measurements of the given kind for all validators, it's zero if
+		// nothing is measured yet.
+		Percentile(kind RTTKind, p float64) time.Duration
+	}
+
+	// RTTStats is a default RTTEstimator implementation keeping a fixed
+	// window of recent measurements for every kind. Its state can be saved
+	// with Estimates and restored with Seed to get proper estimates right
+	// after restart.
+	//
+	// Keys implementing encoding.BinaryMarshaler or having Bytes() []byte
+	// method are identified by their serialized form, so different instances
+	// of the same key match. Other keys are compared with ==, measurements
+	// for non-comparable ones are only used for Average and Percentile.
+	RTTStats struct {
+		windows [rttKinds]rttWindow
+	}
+
+	// ValidatorRTT is a smoothed round-trip time estimate for a validator.
+	ValidatorRTT struct {
+		Key PublicKey
+		RTT time.Duration
+	}
+
+	rttWindow struct {
+		times []time.Duration
+		idx   int
+		avg   time.Duration
+		est   []ValidatorRTT
+		index map[any]int // Key ID to est index.
+	}
+)
+
+// NewRTTStats returns RTTStats keeping window measurements (DefaultRTTWindow
+// if window is not positive) of every kind.
+func NewRTTStats(window int) *RTTStats {
+	if window <= 0 {
+		window = DefaultRTTWindow
+	}
+	var r = new(RTTStats)
+	for i := range r.windows {
+		r.windows[i].times = make([]time.Duration, window)
+	}
+	return r
+}
+
+func (r *RTTStats) window(kind RTTKind) *rttWindow {
+	if int(kind) >= len(r.windows) {
+		return nil
+	}
+	return &r.windows[kind]
 }
 
-func (r *rtt) addTime(t time.Duration) {
-	var old = r.times[r.idx]
+// Add implements RTTEstimator interface.
+func (r *RTTStats) Add(kind RTTKind, validator PublicKey, t time.Duration) {
+	var w = r.window(kind)
+	if w == nil {
+		return
+	}
+	var old = w.times[w.idx]
 
 	if old != 0 {
 		t = min(t, 2*old) // Too long delays should be normalized, we don't want to overshoot.
 	}
 
-	r.avg = r.avg + (t-old)/time.Duration(len(r.times))
-	r.avg = max(0, r.avg) // Can't be less than zero.
-	r.times[r.idx] = t
-	r.idx = (r.idx + 1) % len(r.times)
+	w.avg = w.avg + (t-old)/time.Duration(len(w.times))
+	w.avg = max(0, w.avg) // Can't be less than zero.
+	w.times[w.idx] = t
+	w.idx = (w.idx + 1) % len(w.times)
+
+	id, ok := keyID(validator)
+	if !ok {
+		return
+	}
+	i, ok := w.index[id]
+	if !ok {
+		w.setEstimate(id, validator, t)
+		return
+	}
+	// Smoothed the same way as TCP does, RFC 6298.
+	w.est[i].RTT = (7*w.est[i].RTT + t) / 8
+}
+
+func (w *rttWindow) setEstimate(id any, k PublicKey, t time.Duration) {
+	if w.index == nil {
+		w.index = make(map[any]int)
+	}
+	if i, ok := w.index[id]; ok {
+		w.est[i].RTT = t
+		return
+	}
+	w.index[id] = len(w.est)
+	w.est = append(w.est, ValidatorRTT{Key: k, RTT: t})
+}
+
+// Average implements RTTEstimator interface.
+func (r *RTTStats) Average(kind RTTKind) time.Duration {
+	if w := r.window(kind); w != nil {
+		return w.avg
+	}
+	return 0
+}
+
+// Estimate implements RTTEstimator interface.
+func (r *RTTStats) Estimate(kind RTTKind, validator PublicKey) time.Duration {
+	var w = r.window(kind)
+	if w == nil {
+		return 0
+	}
+	if id, ok := keyID(validator); ok {
+		if i, ok := w.index[id]; ok {
+			return w.est[i].RTT
+		}
+	}
+	return 0
+}
+
+// Percentile implements RTTEstimator interface.
+func (r *RTTStats) Percentile(kind RTTKind, p float64) time.Duration {
+	var w = r.window(kind)
+	if w == nil {
+		return 0
+	}
+	var times = make([]time.Duration, 0, len(w.times))
+	for _, t := range w.times {
+		if t != 0 {
+			times = append(times, t)
+		}
+	}
+	if len(times) == 0 {
+		return 0
+	}
+	slices.Sort(times)
+	p = min(max(p, 0), 100)
+	return times[int(p*float64(len(times)-1)/100+0.5)]
+}
+
+// Estimates returns per-validator estimates of the given kind to be persisted
+// and passed to Seed later.
+func (r *RTTStats) Estimates(kind RTTKind) []ValidatorRTT {
+	if w := r.window(kind); w != nil {
+		return slices.Clone(w.est)
+	}
+	return nil
+}
+
+// Seed initializes RTTStats with previously saved per-validator estimates of
+// the given kind, measurements window is filled with them as well, so Average
+// and Percentile can be used right away.
+func (r *RTTStats) Seed(kind RTTKind, estimates []ValidatorRTT) {
+	var w = r.window(kind)
+	if w == nil {
+		return
+	}
+	clear(w.times)
+	w.idx = 0
+	w.avg = 0
+	w.est = nil
+	w.index = nil
+
+	var known = make([]time.Duration, 0, len(estimates))
+	for _, e := range estimates {
+		if id, ok := keyID(e.Key); ok {
+			w.setEstimate(id, e.Key, e.RTT)
+		}
+		if e.RTT > 0 {
+			known = append(known, e.RTT)
+		}
+	}
+	if len(known) == 0 {
+		return
+	}
+	var sum time.Duration
+	for i := range w.times {
+		w.times[i] = known[i%len(known)]
+		sum += w.times[i]
+	}
+	w.avg = sum / time.Duration(len(w.times))
 }
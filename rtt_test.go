@@ -0,0 +1,84 @@
+package dbft
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// bytesKey is a non-comparable key identified by its Bytes.
+type bytesKey []byte
+
+func (k bytesKey) Bytes() []byte { return k }
+
+func TestRTTStats(t *testing.T) {
+	r := NewRTTStats(4)
+	require.Zero(t, r.Average(PrepareRTT))
+	require.Zero(t, r.Percentile(PrepareRTT, 50))
+	require.Zero(t, r.Estimate(PrepareRTT, "k1"))
+
+	r.Add(PrepareRTT, "k1", 100*time.Millisecond)
+	require.Equal(t, 25*time.Millisecond, r.Average(PrepareRTT))
+	require.Equal(t, 100*time.Millisecond, r.Estimate(PrepareRTT, "k1"))
+	require.Zero(t, r.Estimate(PrepareRTT, "k0"))
+
+	r.Add(PrepareRTT, "k1", 180*time.Millisecond)
+	require.Equal(t, 110*time.Millisecond, r.Estimate(PrepareRTT, "k1"))
+	r.Add(PrepareRTT, "k0", 200*time.Millisecond)
+	r.Add(PrepareRTT, "k2", 300*time.Millisecond)
+	require.Equal(t, 195*time.Millisecond, r.Average(PrepareRTT))
+	require.Equal(t, 100*time.Millisecond, r.Percentile(PrepareRTT, 0))
+	require.Equal(t, 200*time.Millisecond, r.Percentile(PrepareRTT, 50))
+	require.Equal(t, 300*time.Millisecond, r.Percentile(PrepareRTT, 100))
+
+	// Too long delays are normalized.
+	r.Add(PrepareRTT, "k2", time.Second)
+	require.Equal(t, 180*time.Millisecond, r.Percentile(PrepareRTT, 0))
+	require.Equal(t, 300*time.Millisecond, r.Percentile(PrepareRTT, 100))
+
+	t.Run("kinds", func(t *testing.T) {
+		r := NewRTTStats(4)
+		r.Add(CommitRTT, "k1", 400*time.Millisecond)
+		require.Zero(t, r.Average(PrepareRTT))
+		require.Zero(t, r.Estimate(PrepareRTT, "k1"))
+		require.Equal(t, 100*time.Millisecond, r.Average(CommitRTT))
+		require.Equal(t, 400*time.Millisecond, r.Estimate(CommitRTT, "k1"))
+		require.Zero(t, r.Average(rttKinds))
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		r := NewRTTStats(4)
+		r.Add(PrepareRTT, bytesKey{1, 2}, 100*time.Millisecond)
+		require.Equal(t, 100*time.Millisecond, r.Estimate(PrepareRTT, bytesKey{1, 2}))
+		require.Zero(t, r.Estimate(PrepareRTT, bytesKey{1}))
+		r.Add(PrepareRTT, nil, 100*time.Millisecond)
+		require.Len(t, r.Estimates(PrepareRTT), 1)
+
+		// Non-comparable keys without serialized form are only used for
+		// Average.
+		r.Add(PrepareRTT, []byte{1, 2}, 100*time.Millisecond)
+		r.Add(PrepareRTT, struct{ k any }{[]byte{1}}, 100*time.Millisecond)
+		require.Len(t, r.Estimates(PrepareRTT), 1)
+		require.Zero(t, r.Estimate(PrepareRTT, []byte{1, 2}))
+		require.Equal(t, 100*time.Millisecond, r.Average(PrepareRTT))
+	})
+
+	t.Run("seed", func(t *testing.T) {
+		est := r.Estimates(PrepareRTT)
+		require.Len(t, est, 3)
+
+		r2 := NewRTTStats(0)
+		r2.Seed(PrepareRTT, est)
+		require.Len(t, r2.windows[PrepareRTT].times, DefaultRTTWindow)
+		require.Equal(t, est, r2.Estimates(PrepareRTT))
+		require.Equal(t, r.Estimate(PrepareRTT, "k2"), r2.Estimate(PrepareRTT, "k2"))
+		require.NotZero(t, r2.Average(PrepareRTT))
+		require.Zero(t, r2.Average(CommitRTT))
+		require.Equal(t, est[0].RTT, r2.Percentile(PrepareRTT, 0))
+
+		r2.Seed(PrepareRTT, nil)
+		require.Zero(t, r2.Average(PrepareRTT))
+		require.Empty(t, r2.Estimates(PrepareRTT))
+	})
+}
@@ -2,6 +2,7 @@ package dbft
 
 import (
 	"fmt"
+	"slices"
 
 	"go.uber.org/zap"
 )
@@ -16,6 +17,23 @@ func (d *DBFT[H]) broadcast(msg ConsensusPayload[H]) {
 	d.Broadcast(msg)
 }
 
+// sendTo sends msg to the validator with the given index via Config.SendTo if
+// it's set and broadcasts it otherwise.
+func (d *DBFT[H]) sendTo(validator int, msg ConsensusPayload[H]) {
+	if d.SendTo == nil {
+		d.broadcast(msg)
+		return
+	}
+	d.Logger.Debug("sending message",
+		zap.Stringer("type", msg.Type()),
+		zap.Int("to", validator),
+		zap.Uint32("height", d.BlockIndex),
+		zap.Uint("view", uint(d.ViewNumber)))
+
+	msg.SetValidatorIndex(uint16(d.MyIndex))
+	d.SendTo(validator, msg)
+}
+
 func (c *Context[H]) makePrepareRequest(force bool) ConsensusPayload[H] {
 	if !c.Fill(force) {
 		return nil
@@ -42,12 +60,13 @@ func (d *DBFT[H]) sendPrepareRequest(force bool) {
 	}
 	d.unsubscribeFromTransactions()
 
-	d.PreparationPayloads[d.MyIndex] = msg
+	d.setPreparation(d.MyIndex, msg)
+	d.signPreparation(msg)
 	d.broadcast(msg)
 
 	d.prepareSentTime = d.Timer.Now()
 
-	delay := d.timePerBlock << (d.ViewNumber + 1)
+	delay := d.viewTimeout(d.ViewNumber)
 	if d.ViewNumber == 0 {
 		delay -= d.timePerBlock
 	}
@@ -61,7 +80,7 @@ func (c *Context[H]) makeChangeView(ts uint64, reason ChangeViewReason) Consensu
 	cv := c.Config.NewChangeView(c.ViewNumber+1, reason, ts)
 
 	msg := c.Config.NewConsensusPayload(c, ChangeViewType, cv)
-	c.ChangeViewPayloads[c.MyIndex] = msg
+	c.setChangeView(c.MyIndex, msg)
 
 	return msg
 }
@@ -71,8 +90,18 @@ func (d *DBFT[H]) sendChangeView(reason ChangeViewReason) {
 		return
 	}
 
+	if d.ViewNumber >= d.MaxViewNumber {
+		d.Logger.Warn("maximum view number reached, can't change view",
+			zap.Uint32("height", d.BlockIndex),
+			zap.Uint("view", uint(d.ViewNumber)))
+		d.changeTimer(d.viewTimeout(d.ViewNumber))
+		d.sendRecoveryRequest()
+
+		return
+	}
+
 	newView := d.ViewNumber + 1
-	d.changeTimer(d.timePerBlock << (newView + 1))
+	d.changeTimer(d.viewTimeout(newView))
 
 	nc := d.CountCommitted()
 	nf := d.CountFailed()
@@ -107,18 +136,60 @@ func (c *Context[H]) makePrepareResponse() ConsensusPayload[H] {
 	resp := c.Config.NewPrepareResponse(c.PreparationPayloads[c.PrimaryIndex].Hash())
 
 	msg := c.Config.NewConsensusPayload(c, PrepareResponseType, resp)
-	c.PreparationPayloads[c.MyIndex] = msg
+	c.setPreparation(c.MyIndex, msg)
 
 	return msg
 }
 
 func (d *DBFT[H]) sendPrepareResponse() {
+	// Node requesting view change must not prepare if fast path is enabled,
+	// see Context.fastPathLocked.
+	if d.isFastPathEnabled() && d.ViewChanging() {
+		d.Logger.Debug("skip PrepareResponse: view changing")
+		return
+	}
 	msg := d.makePrepareResponse()
+	d.signPreparation(msg)
 	d.Logger.Info("sending PrepareResponse", zap.Uint32("height", d.BlockIndex), zap.Uint("view", uint(d.ViewNumber)))
 	d.StopTxFlow()
 	d.broadcast(msg)
 }
 
+// signPreparation sets the preparation signature of the header to msg if
+// fast path is enabled, the preparation implements SignedPreparation and the
+// header implements PreparationSigner. Preparation is sent unsigned if the
+// header can't be signed, the block is then approved via Commits only.
+func (d *DBFT[H]) signPreparation(msg ConsensusPayload[H]) {
+	// Nothing can be signed until the previous block is persisted, see
+	// Config.Pipelined.
+	if !d.isFastPathEnabled() || d.pendingBlock != nil {
+		return
+	}
+	sp, ok := preparation(msg).(SignedPreparation)
+	if !ok {
+		return
+	}
+	b := d.MakeHeader()
+	if b == nil {
+		d.Logger.Warn("can't sign preparation: no header")
+		return
+	}
+	if err := d.checkNextValidators(b); err != nil {
+		d.Logger.Warn("can't sign preparation", zap.Error(err))
+		return
+	}
+	ps, ok := b.(PreparationSigner)
+	if !ok {
+		return
+	}
+	sig, err := ps.SignPreparation(d.Priv)
+	if err != nil {
+		d.Logger.Warn("can't sign preparation", zap.Error(err))
+		return
+	}
+	sp.SetSignature(sig)
+}
+
 func (c *Context[H]) makePreCommit() (ConsensusPayload[H], error) {
 	if msg := c.PreCommitPayloads[c.MyIndex]; msg != nil {
 		return msg, nil
@@ -146,6 +217,9 @@ func (c *Context[H]) makeCommit() (ConsensusPayload[H], error) {
 	}
 
 	if b := c.MakeHeader(); b != nil {
+		if err := c.checkNextValidators(b); err != nil {
+			return nil, err
+		}
 		var sign []byte
 		if err := b.Sign(c.Priv); err == nil {
 			sign = b.Signature()
@@ -167,7 +241,7 @@ func (d *DBFT[H]) sendPreCommit() {
 		d.Logger.Error("failed to construct PreCommit", zap.Error(err))
 		return
 	}
-	d.PreCommitPayloads[d.MyIndex] = msg
+	d.setPreCommit(d.MyIndex, msg)
 	d.Logger.Info("sending PreCommit", zap.Uint32("height", d.BlockIndex), zap.Uint("view", uint(d.ViewNumber)))
 	d.broadcast(msg)
 }
@@ -178,7 +252,7 @@ func (d *DBFT[H]) sendCommit() {
 		d.Logger.Error("failed to construct Commit", zap.Error(err))
 		return
 	}
-	d.CommitPayloads[d.MyIndex] = msg
+	d.setCommit(d.MyIndex, msg)
 	d.Logger.Info("sending Commit", zap.Uint32("height", d.BlockIndex), zap.Uint("view", uint(d.ViewNumber)))
 	d.broadcast(msg)
 }
@@ -186,20 +260,58 @@ func (d *DBFT[H]) sendCommit() {
 func (d *DBFT[H]) sendRecoveryRequest() {
 	// If we're here, something is wrong, we either missing some messages or
 	// transactions or both, so re-request missing transactions here too.
+	// ChangeView is not sent on verification errors, the node can't prepare
+	// anyway and will change view on timeout (sending ChangeView from here
+	// can lead to recursion).
 	if d.RequestSentOrReceived() && !d.hasAllTransactions() {
-		d.processMissingTx()
+		if err := d.processMissingTx(); err != nil {
+			d.Logger.Info("proposed transaction rejected, waiting for view change",
+				zap.Uint32("height", d.BlockIndex),
+				zap.Uint("view", uint(d.ViewNumber)),
+				zap.Error(err))
+		}
 	}
 	req := d.NewRecoveryRequest(uint64(d.Timer.Now().UnixNano()))
+	if t, ok := req.(TargetedRecoveryRequest); ok {
+		t.SetKnown(d.knownPayloads())
+	}
 	d.broadcast(d.NewConsensusPayload(&d.Context, RecoveryRequestType, req))
 }
 
-func (c *Context[H]) makeRecoveryMessage() ConsensusPayload[H] {
-	recovery := c.Config.NewRecoveryMessage()
+// makeRecoveryMessage creates RecoveryMessage containing all payloads of the
+// current view or only the ones missing on the sender of req if it's
+// TargetedRecoveryRequest.
+func (c *Context[H]) makeRecoveryMessage(req ConsensusPayload[H]) ConsensusPayload[H] {
+	var (
+		recovery = c.Config.NewRecoveryMessage()
+		known    KnownPayloads
+		view     View
+	)
+	if req != nil && req.Type() == RecoveryRequestType {
+		if t, ok := req.GetRecoveryRequest().(TargetedRecoveryRequest); ok && t.Known() != nil {
+			known, view = *t.Known(), req.ViewNumber()
+		}
+	}
+	missing := func(p ConsensusPayload[H], known Bitmap) bool {
+		return p != nil && (p.ViewNumber() != view || !known.IsSet(int(p.ValidatorIndex())))
+	}
+	add := func(p ConsensusPayload[H], known Bitmap) {
+		if missing(p, known) {
+			recovery.AddPayload(p)
+		}
+	}
 
+	// PrepareRequest is required to extract PrepareResponses, so it's
+	// included if any preparation is missing.
+	var withRequest = slices.ContainsFunc(c.PreparationPayloads, func(p ConsensusPayload[H]) bool {
+		return missing(p, known.Preparations)
+	})
 	for _, p := range c.PreparationPayloads {
-		if p != nil {
+		if withRequest && p != nil && p.Type() == PrepareRequestType {
 			recovery.AddPayload(p)
+			continue
 		}
+		add(p, known.Preparations)
 	}
 
 	cv := c.LastChangeViewPayloads
@@ -207,30 +319,64 @@ func (c *Context[H]) makeRecoveryMessage() ConsensusPayload[H] {
 	// 	cv = c.changeViewPayloads
 	// }
 	for _, p := range cv {
-		if p != nil {
+		// ChangeViews are known by the view they lead to.
+		if p != nil && (p.GetChangeView().NewViewNumber() != view || !known.ChangeViews.IsSet(int(p.ValidatorIndex()))) {
 			recovery.AddPayload(p)
 		}
 	}
 
 	if c.PreCommitSent() {
 		for _, p := range c.PreCommitPayloads {
-			if p != nil {
-				recovery.AddPayload(p)
-			}
+			add(p, known.PreCommits)
 		}
 	}
 
 	if c.CommitSent() {
 		for _, p := range c.CommitPayloads {
-			if p != nil {
-				recovery.AddPayload(p)
-			}
+			add(p, known.Commits)
 		}
 	}
 
 	return c.Config.NewConsensusPayload(c, RecoveryMessageType, recovery)
 }
 
+// knownPayloads returns payloads of the current view the node has.
+func (c *Context[H]) knownPayloads() *KnownPayloads {
+	var (
+		n     = len(c.Validators)
+		known = &KnownPayloads{
+			Preparations: NewBitmap(n),
+			PreCommits:   NewBitmap(n),
+			Commits:      NewBitmap(n),
+			ChangeViews:  NewBitmap(n),
+		}
+	)
+	for _, s := range []struct {
+		payloads []ConsensusPayload[H]
+		bitmap   Bitmap
+	}{
+		{c.PreparationPayloads, known.Preparations},
+		{c.PreCommitPayloads, known.PreCommits},
+		{c.CommitPayloads, known.Commits},
+	} {
+		for i, p := range s.payloads {
+			if p != nil && p.ViewNumber() == c.ViewNumber {
+				s.bitmap.Set(i)
+			}
+		}
+	}
+	// ChangeViews are sent at the previous views.
+	for i, p := range c.LastChangeViewPayloads {
+		if p != nil && p.GetChangeView().NewViewNumber() == c.ViewNumber {
+			known.ChangeViews.Set(i)
+		}
+	}
+	return known
+}
+
+// sendRecoveryMessage broadcasts RecoveryMessage re-sending node's own
+// payloads. SendTo is not used here, payloads can be missed by any node and
+// those that need them most don't necessarily send RecoveryRequest.
 func (d *DBFT[H]) sendRecoveryMessage() {
-	d.broadcast(d.makeRecoveryMessage())
+	d.broadcast(d.makeRecoveryMessage(nil))
 }
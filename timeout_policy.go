@@ -0,0 +1,170 @@
+package dbft
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+type (
+	// TimeoutParams contains data available to TimeoutPolicy for timeout
+	// calculation.
+	TimeoutParams struct {
+		// TimePerBlock is the current TimePerBlock value.
+		TimePerBlock time.Duration
+		// View is the view timeout is calculated for.
+		View View
+		// RTT is an average round-trip time to other validators measured
+		// by this node (PrepareRTT for primary and CommitRTT for backups),
+		// it's zero if not yet measured.
+		RTT time.Duration
+		// Retry is set for CommitTimeout when Commit was already resent at
+		// least once.
+		Retry bool
+	}
+
+	// TimeoutPolicy defines timeouts dBFT uses to detect failures. It's
+	// consulted every time timer is reset in a view after it's started
+	// (except for block time extension, see Config.MaxTimePerBlock).
+	TimeoutPolicy interface {
+		// ViewTimeout returns time to wait for block acceptance in the
+		// given view before requesting view change.
+		ViewTimeout(p TimeoutParams) time.Duration
+		// CommitTimeout returns time to wait after Commit (or PreCommit)
+		// is sent before resending it via RecoveryMessage.
+		CommitTimeout(p TimeoutParams) time.Duration
+	}
+
+	// ExponentialTimeout is a standard dBFT policy that doubles the timeout
+	// starting from 2*TimePerBlock for view 0 with every view. The growth
+	// stops at MaxShift view.
+	ExponentialTimeout struct {
+		// MaxShift limits the number of doublings, it's 16 if not set.
+		MaxShift uint
+	}
+
+	// LinearTimeout increases view timeout by Step for every view starting
+	// from 2*TimePerBlock for view 0. It allows small networks to recover
+	// from failed primaries faster than with ExponentialTimeout.
+	LinearTimeout struct {
+		// Step is the timeout increment, TimePerBlock is used if not set.
+		Step time.Duration
+		// Max limits the timeout if set.
+		Max time.Duration
+	}
+
+	// JitteredTimeout adds random jitter up to Fraction of the timeout
+	// returned by Policy, it prevents nodes from changing views in lockstep.
+	// DefaultTimeoutPolicy is used if Policy is not set.
+	JitteredTimeout struct {
+		Policy   TimeoutPolicy
+		Fraction float64
+	}
+
+	// RTTAdaptiveTimeout adds Factor round-trip times to the timeouts
+	// returned by Policy to account for network latency. DefaultTimeoutPolicy
+	// is used if Policy is not set.
+	RTTAdaptiveTimeout struct {
+		Policy TimeoutPolicy
+		Factor int
+	}
+)
+
+// defaultMaxTimeoutShift is the default ExponentialTimeout.MaxShift value.
+const defaultMaxTimeoutShift = 16
+
+// DefaultTimeoutPolicy is the timeout policy used by default.
+var DefaultTimeoutPolicy TimeoutPolicy = ExponentialTimeout{}
+
+// ViewTimeout implements TimeoutPolicy interface.
+func (e ExponentialTimeout) ViewTimeout(p TimeoutParams) time.Duration {
+	var maxShift = e.MaxShift
+	if maxShift == 0 {
+		maxShift = defaultMaxTimeoutShift
+	}
+	shift := min(uint(p.View)+1, maxShift)
+	if p.TimePerBlock > math.MaxInt64>>shift {
+		return math.MaxInt64
+	}
+	return p.TimePerBlock << shift
+}
+
+// CommitTimeout implements TimeoutPolicy interface. It's TimePerBlock for the
+// first attempt and 2*TimePerBlock for retries.
+func (e ExponentialTimeout) CommitTimeout(p TimeoutParams) time.Duration {
+	if p.Retry {
+		return p.TimePerBlock << 1
+	}
+	return p.TimePerBlock
+}
+
+// ViewTimeout implements TimeoutPolicy interface.
+func (l LinearTimeout) ViewTimeout(p TimeoutParams) time.Duration {
+	var step = l.Step
+	if step == 0 {
+		step = p.TimePerBlock
+	}
+	var (
+		base = p.TimePerBlock << 1
+		res  time.Duration
+	)
+	if step > (math.MaxInt64-base)/(time.Duration(p.View)+1) {
+		res = math.MaxInt64
+	} else {
+		res = base + step*time.Duration(p.View)
+	}
+	if l.Max != 0 {
+		res = min(res, l.Max)
+	}
+	return res
+}
+
+// CommitTimeout implements TimeoutPolicy interface. It's the same as for
+// ExponentialTimeout.
+func (l LinearTimeout) CommitTimeout(p TimeoutParams) time.Duration {
+	return ExponentialTimeout{}.CommitTimeout(p)
+}
+
+// ViewTimeout implements TimeoutPolicy interface.
+func (j JitteredTimeout) ViewTimeout(p TimeoutParams) time.Duration {
+	return j.jitter(orDefault(j.Policy).ViewTimeout(p))
+}
+
+// CommitTimeout implements TimeoutPolicy interface.
+func (j JitteredTimeout) CommitTimeout(p TimeoutParams) time.Duration {
+	return j.jitter(orDefault(j.Policy).CommitTimeout(p))
+}
+
+func (j JitteredTimeout) jitter(t time.Duration) time.Duration {
+	var d = time.Duration(float64(t) * j.Fraction)
+	if d <= 0 || t > math.MaxInt64-d {
+		return t
+	}
+	return t + rand.N(d)
+}
+
+// ViewTimeout implements TimeoutPolicy interface.
+func (a RTTAdaptiveTimeout) ViewTimeout(p TimeoutParams) time.Duration {
+	return a.adapt(orDefault(a.Policy).ViewTimeout(p), p.RTT)
+}
+
+// CommitTimeout implements TimeoutPolicy interface.
+func (a RTTAdaptiveTimeout) CommitTimeout(p TimeoutParams) time.Duration {
+	return a.adapt(orDefault(a.Policy).CommitTimeout(p), p.RTT)
+}
+
+func (a RTTAdaptiveTimeout) adapt(t time.Duration, rtt time.Duration) time.Duration {
+	var d = rtt * time.Duration(a.Factor)
+	if d <= 0 || t > math.MaxInt64-d {
+		return t
+	}
+	return t + d
+}
+
+// orDefault returns DefaultTimeoutPolicy if p is nil and p otherwise.
+func orDefault(p TimeoutPolicy) TimeoutPolicy {
+	if p == nil {
+		return DefaultTimeoutPolicy
+	}
+	return p
+}
@@ -10,13 +10,51 @@ type Timer interface {
 	// Now returns current time.
 	Now() time.Time
 	// Reset resets timer to the specified block height and view.
-	Reset(height uint32, view byte, d time.Duration)
+	Reset(height uint32, view View, d time.Duration)
 	// Extend extends current timer with duration d.
 	Extend(d time.Duration)
 	// Height returns current height set for the timer.
 	Height() uint32
 	// View returns current view set for the timer.
-	View() byte
+	View() View
 	// C returns channel for timer events.
 	C() <-chan time.Time
 }
+
+// ByteViewTimer is a Timer with single-byte view numbers as it was defined
+// before View type introduction. It's only provided to simplify migration,
+// see NewByteViewTimer.
+//
+// Deprecated: implement Timer instead.
+type ByteViewTimer interface {
+	Now() time.Time
+	Reset(height uint32, view byte, d time.Duration)
+	Extend(d time.Duration)
+	Height() uint32
+	View() byte
+	C() <-chan time.Time
+}
+
+// byteViewTimer adapts ByteViewTimer to Timer.
+type byteViewTimer struct {
+	ByteViewTimer
+}
+
+// NewByteViewTimer wraps ByteViewTimer into Timer. It can only be used with
+// Config.MaxViewNumber not exceeding DefaultMaxViewNumber (which is the
+// default), higher views can't be represented by the wrapped timer.
+//
+// Deprecated: implement Timer instead.
+func NewByteViewTimer(t ByteViewTimer) Timer {
+	return byteViewTimer{t}
+}
+
+// Reset implements Timer interface.
+func (t byteViewTimer) Reset(height uint32, view View, d time.Duration) {
+	t.ByteViewTimer.Reset(height, byte(view), d)
+}
+
+// View implements Timer interface.
+func (t byteViewTimer) View() View {
+	return View(t.ByteViewTimer.View())
+}
@@ -6,13 +6,15 @@ package timer
 
 import (
 	"time"
+
+	"github.com/nspcc-dev/dbft"
 )
 
 type (
 	// Timer is a default [dbft.Timer] implementation.
 	Timer struct {
 		height uint32
-		view   byte
+		view   dbft.View
 		s      time.Time
 		d      time.Duration
 		tt     *time.Timer
@@ -44,12 +46,12 @@ func (t *Timer) Height() uint32 {
 }
 
 // View return current timer view.
-func (t *Timer) View() byte {
+func (t *Timer) View() dbft.View {
 	return t.view
 }
 
 // Reset implements Timer interface.
-func (t *Timer) Reset(height uint32, view byte, d time.Duration) {
+func (t *Timer) Reset(height uint32, view dbft.View, d time.Duration) {
 	t.stop()
 
 	t.s = t.Now()
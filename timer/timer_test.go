@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/nspcc-dev/dbft"
 	"github.com/stretchr/testify/require"
 )
 
@@ -35,7 +36,7 @@ func TestTimer_Reset(t *testing.T) {
 	shouldReceive(t, tt, 3, 1, "no value in timer after extend")
 }
 
-func shouldReceive(t *testing.T, tt *Timer, height uint32, view byte, msg string) {
+func shouldReceive(t *testing.T, tt *Timer, height uint32, view dbft.View, msg string) {
 	select {
 	case <-tt.C():
 		gotHeight := tt.Height()
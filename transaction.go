@@ -1,8 +1,31 @@
 package dbft
 
+import "errors"
+
+var (
+	// ErrTxRejectedByPolicy is returned (possibly wrapped) from
+	// Config.VerifyTransaction if transaction is valid, but it can't be
+	// accepted by the node policy. ChangeView with CVTxRejectedByPolicy reason
+	// is sent then.
+	ErrTxRejectedByPolicy = errors.New("transaction rejected by policy")
+	// ErrTxInvalid is returned (possibly wrapped) from
+	// Config.VerifyTransaction if transaction is invalid. ChangeView with
+	// CVTxInvalid reason is sent for this and any other unknown error.
+	ErrTxInvalid = errors.New("invalid transaction")
+)
+
 // Transaction is a generic transaction interface.
 type Transaction[H Hash] interface {
 	// Hash must return cryptographic hash of the transaction.
 	// Transactions which have equal hashes are considered equal.
 	Hash() H
 }
+
+// SizedTransaction is an optional Transaction extension that allows to
+// enforce Config.MaxProposalSize. Transactions that don't implement it are
+// considered to have zero size.
+type SizedTransaction[H Hash] interface {
+	Transaction[H]
+	// Size returns transaction size in bytes.
+	Size() int
+}
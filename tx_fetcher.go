@@ -0,0 +1,128 @@
+package dbft
+
+import (
+	"slices"
+	"time"
+)
+
+type (
+	// txFetcher requests missing transactions of the proposal and, if
+	// Config.TxRetryInterval is set, re-requests them with exponential
+	// backoff until they're received or the proposal is discarded. Retries
+	// are driven by DBFT.OnTxRetry calls, all times are taken from
+	// Config.Timer.
+	txFetcher[H Hash] struct {
+		cfg *Config[H]
+
+		pending map[H]*txFetch
+		// holders are validators known to have proposed transactions.
+		holders []int
+		attempt int
+		// next is the time of the next retry, it's zero if no retry is
+		// scheduled.
+		next time.Time
+	}
+
+	txFetch struct {
+		start    time.Time
+		attempts int
+	}
+)
+
+func newTxFetcher[H Hash](cfg *Config[H]) *txFetcher[H] {
+	return &txFetcher[H]{
+		cfg:     cfg,
+		pending: make(map[H]*txFetch),
+	}
+}
+
+// fetch requests hashes from the holder (proposer) and schedules retries if
+// needed. Hashes that are already being fetched are requested again.
+func (f *txFetcher[H]) fetch(holder int, hashes []H) {
+	now := f.cfg.Timer.Now()
+	for _, h := range hashes {
+		if p, ok := f.pending[h]; ok {
+			p.attempts++
+		} else {
+			f.pending[h] = &txFetch{start: now, attempts: 1}
+		}
+	}
+	f.addHolder(holder)
+	if f.next.IsZero() && f.cfg.TxRetryInterval > 0 {
+		f.attempt = 0
+		f.schedule(now)
+	}
+
+	f.request(holder, hashes)
+}
+
+// addHolder adds validator that is known to have all proposed transactions.
+func (f *txFetcher[H]) addHolder(holder int) {
+	if !slices.Contains(f.holders, holder) {
+		f.holders = append(f.holders, holder)
+	}
+}
+
+// received marks transaction as fetched and reports it via
+// Config.OnTxFetched.
+func (f *txFetcher[H]) received(h H) {
+	p, ok := f.pending[h]
+	if !ok {
+		return
+	}
+	delete(f.pending, h)
+	if len(f.pending) == 0 {
+		f.next = time.Time{}
+	}
+
+	if f.cfg.OnTxFetched != nil {
+		f.cfg.OnTxFetched(h, f.cfg.Timer.Now().Sub(p.start), p.attempts)
+	}
+}
+
+// stop forgets all pending transactions and holders.
+func (f *txFetcher[H]) stop() {
+	clear(f.pending)
+	f.holders = f.holders[:0]
+	f.next = time.Time{}
+}
+
+func (f *txFetcher[H]) schedule(now time.Time) {
+	delay := f.cfg.TxRetryInterval << min(f.attempt, 32)
+	if delay > f.cfg.MaxTxRetryInterval || delay <= 0 {
+		delay = f.cfg.MaxTxRetryInterval
+	}
+	f.next = now.Add(delay)
+}
+
+// retry re-requests pending transactions from the next holder if the retry
+// time has come.
+func (f *txFetcher[H]) retry() {
+	if f.next.IsZero() || len(f.pending) == 0 {
+		return
+	}
+	now := f.cfg.Timer.Now()
+	if now.Before(f.next) {
+		return
+	}
+	f.attempt++
+	var (
+		holder = f.holders[f.attempt%len(f.holders)]
+		hashes = make([]H, 0, len(f.pending))
+	)
+	for h, p := range f.pending {
+		hashes = append(hashes, h)
+		p.attempts++
+	}
+	f.schedule(now)
+
+	f.request(holder, hashes)
+}
+
+func (f *txFetcher[H]) request(holder int, hashes []H) {
+	if f.cfg.RequestTxFrom != nil {
+		f.cfg.RequestTxFrom(holder, hashes...)
+	} else {
+		f.cfg.RequestTx(hashes...)
+	}
+}
@@ -0,0 +1,257 @@
+package dbft
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// VerificationTask is a block or payload verification job passed to
+// Config.VerifyAsync in asynchronous verification mode.
+type VerificationTask[H Hash] struct {
+	// Height is a block index the task was created at.
+	Height uint32
+	// View is a view number the task was created at.
+	View View
+	// Payload is a payload being verified, it's nil for block (PreBlock)
+	// verification tasks.
+	Payload ConsensusPayload[H]
+	// Recovery is RecoveryMessage payload the Payload was extracted from, it's
+	// nil for payloads received directly.
+	Recovery ConsensusPayload[H]
+	// Verify performs verification via the corresponding Config callback.
+	// It doesn't access dBFT state and can be called from any goroutine.
+	Verify func() error
+
+	done func(err error)
+}
+
+// OnVerificationResult processes the result of the task passed to
+// Config.VerifyAsync, err is the value returned from task's Verify. Results
+// of the tasks created at the previous heights or views are ignored where
+// appropriate, payloads are processed just like they're received via
+// OnReceive.
+func (d *DBFT[H]) OnVerificationResult(t *VerificationTask[H], err error) {
+	if t.done == nil {
+		return
+	}
+	done := t.done
+	t.done = nil
+	done(err)
+}
+
+// verifyPayload checks msg with the given Verify* callback. In asynchronous
+// verification mode payloads are checked before processing, so the result
+// of this check is returned. Payloads failed verification are not considered
+// to be seen, since the result may depend on the node state.
+func (d *DBFT[H]) verifyPayload(msg ConsensusPayload[H], f func(ConsensusPayload[H]) error) error {
+	err := d.verificationErr
+	if d.VerifyAsync == nil {
+		err = f(msg)
+	}
+	if err != nil {
+		d.seen.remove(msg.Hash())
+	}
+	return err
+}
+
+// payloadVerifier returns Verify* callback for the given message type.
+func (d *DBFT[H]) payloadVerifier(t MessageType) func(ConsensusPayload[H]) error {
+	switch t {
+	case ChangeViewType:
+		return d.VerifyChangeView
+	case PrepareRequestType:
+		return d.VerifyPrepareRequest
+	case PrepareResponseType:
+		return d.VerifyPrepareResponse
+	case PreCommitType:
+		return d.VerifyPreCommit
+	case CommitType:
+		return d.VerifyCommit
+	case RecoveryRequestType:
+		return d.VerifyRecoveryRequest
+	case RecoveryMessageType:
+		return d.VerifyRecoveryMessage
+	default:
+		return nil
+	}
+}
+
+// verifyPayloadAsync passes msg verification to Config.VerifyAsync, msg is
+// processed once the result is received.
+func (d *DBFT[H]) verifyPayloadAsync(msg ConsensusPayload[H]) {
+	var (
+		verify   = d.payloadVerifier(msg.Type())
+		recovery = d.recovery
+	)
+	if verify == nil {
+		d.handle(msg)
+		return
+	}
+	d.VerifyAsync(&VerificationTask[H]{
+		Height:   d.BlockIndex,
+		View:     d.ViewNumber,
+		Payload:  msg,
+		Recovery: recovery,
+		Verify:   func() error { return verify(msg) },
+		done: func(err error) {
+			var pending = d.isPendingRecoveryRequest(msg)
+			// The block could be accepted while the payload was verified.
+			if !pending && (msg.Height() != d.BlockIndex || d.BlockSent() && msg.Type() != RecoveryRequestType) {
+				return
+			}
+			prevRecovery, prevErr := d.recovery, d.verificationErr
+			d.recovery, d.verificationErr = recovery, err
+			if pending {
+				d.onPendingRecoveryRequest(msg)
+			} else {
+				d.handle(msg)
+			}
+			d.recovery, d.verificationErr = prevRecovery, prevErr
+		},
+	})
+}
+
+// verifyBlock checks the proposed block (or PreBlock) and calls done with the
+// result, in asynchronous verification mode it's done once the result is
+// received unless height or view is changed by that time.
+func (d *DBFT[H]) verifyBlock(done func(err error)) {
+	var verify func() error
+	if d.isAntiMEVExtensionEnabled() {
+		b, f := d.CreatePreBlock(), d.VerifyPreBlock
+		verify = func() error {
+			if !f(b) {
+				return errors.New("proposed preBlock fails verification")
+			}
+			return nil
+		}
+	} else {
+		b, f := d.CreateBlock(), d.VerifyBlock
+		if err := d.checkNextValidators(b); err != nil {
+			done(err)
+			return
+		}
+		verify = func() error {
+			if !f(b) {
+				return errors.New("proposed block fails verification")
+			}
+			return nil
+		}
+	}
+
+	if d.VerifyAsync == nil {
+		done(verify())
+		return
+	}
+
+	height, view := d.BlockIndex, d.ViewNumber
+	d.blockVerifying = true
+	d.VerifyAsync(&VerificationTask[H]{
+		Height: height,
+		View:   view,
+		Verify: verify,
+		done: func(err error) {
+			if height != d.BlockIndex || view != d.ViewNumber || d.BlockSent() {
+				return
+			}
+			d.blockVerifying = false
+			done(err)
+		},
+	})
+}
+
+// preverify verifies signatures (PreCommit data) of msgs extracted from
+// RecoveryMessage that are to be applied to the current view at once using
+// verify method of v (Block or PreBlock). Results are saved to be used by
+// the corresponding payload handlers.
+func (d *DBFT[H]) preverify(msgs []ConsensusPayload[H], existing []ConsensusPayload[H], v any,
+	verify func(PublicKey, []byte) error, sign func(ConsensusPayload[H]) []byte) {
+	var (
+		batch []ConsensusPayload[H]
+		keys  []PublicKey
+		signs [][]byte
+	)
+	for _, m := range msgs {
+		i := int(m.ValidatorIndex())
+		if m.ViewNumber() != d.ViewNumber || i >= len(existing) || existing[i] != nil {
+			continue
+		}
+		batch = append(batch, m)
+		keys = append(keys, d.Validators[i])
+		signs = append(signs, sign(m))
+	}
+	if len(batch) < 2 {
+		return
+	}
+
+	errs := verifySignatures(v, verify, keys, signs, d.RecoveryVerificationWorkers)
+	if d.preverified == nil {
+		d.preverified = make(map[H]error, len(batch))
+	}
+	for i, m := range batch {
+		d.preverified[m.Hash()] = errs[i]
+	}
+}
+
+// preverifyPreCommits verifies PreCommit data of recovered msgs against the
+// current PreBlock if it can be constructed.
+func (d *DBFT[H]) preverifyPreCommits(msgs []ConsensusPayload[H]) {
+	// Payloads are handled after the recovery in asynchronous mode.
+	if d.VerifyAsync != nil || len(msgs) < 2 || !d.isAntiMEVExtensionEnabled() || !d.hasAllTransactions() {
+		return
+	}
+	if preBlock := d.CreatePreBlock(); preBlock != nil {
+		d.preverify(msgs, d.PreCommitPayloads, preBlock, preBlock.Verify, func(m ConsensusPayload[H]) []byte {
+			return m.GetPreCommit().Data()
+		})
+	}
+}
+
+// preverifyCommits verifies signatures of recovered Commit msgs against the
+// current header if it can be constructed.
+func (d *DBFT[H]) preverifyCommits(msgs []ConsensusPayload[H]) {
+	if d.VerifyAsync != nil || len(msgs) < 2 {
+		return
+	}
+	if header := d.MakeHeader(); header != nil {
+		d.preverify(msgs, d.CommitPayloads, header, header.Verify, func(m ConsensusPayload[H]) []byte {
+			return m.GetCommit().Signature()
+		})
+	}
+}
+
+// verifySignature returns the result of msg signature verification made by
+// preverify if any, otherwise it calls verify.
+func (d *DBFT[H]) verifySignature(msg ConsensusPayload[H], verify func() error) error {
+	if err, ok := d.preverified[msg.Hash()]; ok {
+		return err
+	}
+	return verify()
+}
+
+// verifySignatures checks signs[i] made with keys[i] using BatchVerifier if
+// v implements it or verify called from the given number of goroutines.
+func verifySignatures(v any, verify func(PublicKey, []byte) error, keys []PublicKey, signs [][]byte, workers int) []error {
+	if bv, ok := v.(BatchVerifier); ok {
+		if errs := bv.VerifyBatch(keys, signs); len(errs) == len(keys) {
+			return errs
+		}
+	}
+
+	var (
+		errs = make([]error, len(keys))
+		next atomic.Int64
+		wg   sync.WaitGroup
+	)
+	for range min(workers, len(keys)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < len(keys); i = int(next.Add(1) - 1) {
+				errs[i] = verify(keys[i], signs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,15 @@
+package dbft
+
+import (
+	"math"
+)
+
+// View is a consensus view number. dBFT never exceeds Config.MaxViewNumber,
+// so implementations with narrower view representation (like a single byte
+// in Neo consensus messages) can keep their format by setting it
+// accordingly.
+type View uint32
+
+// DefaultMaxViewNumber is the default maximum view number which fits into
+// a single byte.
+const DefaultMaxViewNumber = View(math.MaxUint8)